@@ -1,10 +1,15 @@
 package functions
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func UsersApi(w http.ResponseWriter, r *http.Request) {
@@ -18,6 +23,951 @@ func UsersApi(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// This endpoint returns the home feed, newest first. GET /api/feed?sources=&cursor=&limit=
+// Sources is a comma separated subset of FeedSources and defaults to all of them.
+func FeedApi(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	viewer := LoggedInUser(r)
+	if viewer.Nickname == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(JsonMessage("unauthorized"))
+		return
+	}
+	sources := FeedSources
+	if r.URL.Query().Get("sources") != "" {
+		sources = strings.Split(r.URL.Query().Get("sources"), ",")
+		for _, source := range sources {
+			if !Contains(FeedSources, source) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write(JsonMessage("Unknown feed source: " + source))
+				return
+			}
+		}
+	}
+
+	page, err := GetFeed(viewer, sources, "", r.URL.Query().Get("cursor"), PageLimit(r, 20, 50))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Invalid cursor"))
+		return
+	}
+	content, _ := json.Marshal(page)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// This endpoint searches the posts, comments, group posts, group comments and chat messages
+// the user can see, returning snippets with the matched words highlighted.
+// GET /api/search?q=&type=&author=&from=&to=&cursor=&limit=
+func SearchApi(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	viewer := LoggedInUser(r)
+	if viewer.Nickname == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(JsonMessage("unauthorized"))
+		return
+	}
+	query := r.URL.Query()
+	terms := SearchTerms(query.Get("q"))
+	if len(terms) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Nothing to search for"))
+		return
+	}
+	var types []string
+	if query.Get("type") != "" {
+		types = strings.Split(query.Get("type"), ",")
+		for _, searchType := range types {
+			if !Contains(SearchTypes, searchType) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write(JsonMessage("Unknown search type: " + searchType))
+				return
+			}
+		}
+	}
+	var from, to int64
+	var err error
+	if query.Get("from") != "" {
+		from, err = strconv.ParseInt(query.Get("from"), 10, 64)
+	}
+	if err == nil && query.Get("to") != "" {
+		to, err = strconv.ParseInt(query.Get("to"), 10, 64)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Invalid date range"))
+		return
+	}
+
+	page, err := Search(viewer, terms, types, query.Get("author"), from, to, query.Get("cursor"), PageLimit(r, 20, 50))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Invalid cursor"))
+		return
+	}
+	content, _ := json.Marshal(page)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// This endpoint returns the visible posts and group posts with a hashtag, newest first.
+// GET /api/tags/posts?tag=&cursor=&limit=
+func TagPostsApi(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	viewer := LoggedInUser(r)
+	if viewer.Nickname == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(JsonMessage("unauthorized"))
+		return
+	}
+	tag := NormaliseTag(r.URL.Query().Get("tag"))
+	if tag == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Please provide a tag"))
+		return
+	}
+
+	page, err := GetFeed(viewer, FeedSources, tag, r.URL.Query().Get("cursor"), PageLimit(r, 20, 50))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Invalid cursor"))
+		return
+	}
+	content, _ := json.Marshal(page)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// This endpoint suggests hashtags starting with q. GET /api/tags/autocomplete?q=&limit=
+func TagAutocompleteApi(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	prefix := NormaliseTag(r.URL.Query().Get("q"))
+	if prefix == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Please provide a prefix"))
+		return
+	}
+	content, _ := json.Marshal(AutocompleteTags(prefix, PageLimit(r, 10, 50)))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// This endpoint lists the most used hashtags of the last hours. GET /api/tags/trending?hours=&limit=
+func TrendingTagsApi(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	hours := 24
+	if r.URL.Query().Get("hours") != "" {
+		var err error
+		hours, err = strconv.Atoi(r.URL.Query().Get("hours"))
+		if err != nil || hours < 1 || hours > 24*7 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(JsonMessage("hours must be between 1 and 168"))
+			return
+		}
+	}
+	content, _ := json.Marshal(GetTrendingTags(time.Duration(hours)*time.Hour, PageLimit(r, 10, 50)))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// This endpoint lists the logged in user's mentions, newest first, and marks them as seen.
+// GET /api/mentions?limit=, POST /api/mentions with a mention-type and mention-id, or an empty body for all.
+func MentionsApi(w http.ResponseWriter, r *http.Request) {
+	user := LoggedInUser(r).Nickname
+	if user == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(JsonMessage("unauthorized"))
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		content, _ := json.Marshal(GetMentionNotifications(user, PageLimit(r, 20, 100)))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+	case "POST":
+		var mentionData MentionNotification
+		err := json.NewDecoder(r.Body).Decode(&mentionData)
+		if err != nil && err != io.EOF {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(JsonMessage("Invalid mention"))
+			return
+		}
+		if MarkMentionsSeen(user, mentionData.Type, mentionData.Id) != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(JsonMessage("Please Try Again Later"))
+			return
+		}
+		w.Write(JsonMessage("Mentions marked as seen"))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// This endpoint lists the logged in user's reply notifications, newest first, and marks them as seen.
+// GET /api/replies?limit=, POST /api/replies with a reply-type and reply-id, or an empty body for all.
+func RepliesApi(w http.ResponseWriter, r *http.Request) {
+	user := LoggedInUser(r).Nickname
+	if user == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(JsonMessage("unauthorized"))
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		content, _ := json.Marshal(GetReplyNotifications(user, PageLimit(r, 20, 100)))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+	case "POST":
+		var replyData ReplyNotification
+		err := json.NewDecoder(r.Body).Decode(&replyData)
+		if err != nil && err != io.EOF {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(JsonMessage("Invalid reply"))
+			return
+		}
+		if MarkRepliesSeen(user, replyData.Type, replyData.Id) != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(JsonMessage("Please Try Again Later"))
+			return
+		}
+		w.Write(JsonMessage("Replies marked as seen"))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Whether user can see the post or group post a comment of targetType belongs to.
+func commentPostVisible(targetType, postId, user string) bool {
+	switch targetType {
+	case "comment":
+		return postId != "" && GetPost(postId, user).Id != ""
+	case "group-comment":
+		return postId != "" && GetGroupPost(postId, user).PostId != ""
+	}
+	return false
+}
+
+// This endpoint returns a page of the comments of a post (type=comment) or group post (type=group-comment)
+// as a tree: top level comments sorted oldest, newest or most-reacted first, each with its first replies nested.
+// GET /api/comments?type=&post-id=&sort=&cursor=&limit=
+func CommentsApi(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	user := LoggedInUser(r).Nickname
+	targetType, postId := r.URL.Query().Get("type"), r.URL.Query().Get("post-id")
+	if targetType != "comment" && targetType != "group-comment" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("type must be comment or group-comment"))
+		return
+	}
+	if !commentPostVisible(targetType, postId, user) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(JsonMessage("post not found"))
+		return
+	}
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		sort = "oldest"
+	} else if !Contains(CommentSorts, sort) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("sort must be " + strings.Join(CommentSorts, ", ")))
+		return
+	}
+	page, err := GetComments(targetType, postId, user, sort, r.URL.Query().Get("cursor"), PageLimit(r, CommentPageSize, 50))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Invalid cursor"))
+		return
+	}
+	content, _ := json.Marshal(page)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// This endpoint loads the replies to a comment a page at a time, oldest first, each with its first replies nested.
+// GET /api/comments/replies?type=&comment-id=&cursor=&limit=
+func CommentRepliesApi(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	user := LoggedInUser(r).Nickname
+	targetType, commentId := r.URL.Query().Get("type"), r.URL.Query().Get("comment-id")
+	if targetType != "comment" && targetType != "group-comment" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("type must be comment or group-comment"))
+		return
+	}
+	comment := getTypedComment(targetType, commentId, user)
+	if comment.CommentId == "" || !commentPostVisible(targetType, comment.PostId, user) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(JsonMessage("comment not found"))
+		return
+	}
+	page, err := GetCommentReplies(targetType, comment.CommentId, user, r.URL.Query().Get("cursor"), PageLimit(r, 10, 50))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Invalid cursor"))
+		return
+	}
+	content, _ := json.Marshal(page)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// This endpoint lists the revisions of a post, comment, group post or group comment, oldest first,
+// to anyone who can see it. GET /api/revisions?type=&id=
+func RevisionsApi(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	user := LoggedInUser(r).Nickname
+	targetType, targetId := r.URL.Query().Get("type"), r.URL.Query().Get("id")
+	visible := false
+	switch targetType {
+	case "post":
+		visible = GetPost(targetId, user).Id != ""
+	case "comment":
+		comment := GetComment(targetId, user)
+		visible = comment.CommentId != "" && GetPost(comment.PostId, user).Id != ""
+	case "group-post":
+		visible = GetGroupPost(targetId, user).PostId != ""
+	case "group-comment":
+		comment := GetGroupPostComment(targetId, user)
+		visible = comment.CommentId != "" && GetGroupPost(comment.PostId, user).PostId != ""
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("type must be post, comment, group-post or group-comment"))
+		return
+	}
+	if targetId == "" || !visible {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(JsonMessage(targetType + " not found"))
+		return
+	}
+	content, _ := json.Marshal(GetRevisions(targetType, targetId))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// This endpoint manages the logged in user's drafts and scheduled posts.
+// GET lists them (?state= to filter), POST saves a new one, PUT edits one and DELETE cancels one.
+// A publish-at in the future schedules the post, 0 keeps it as a draft.
+func DraftsApi(w http.ResponseWriter, r *http.Request) {
+	user := LoggedInUser(r).Nickname
+	if user == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(JsonMessage("unauthorized"))
+		return
+	}
+	if r.Method == "GET" {
+		content, _ := json.Marshal(GetDrafts(user, r.URL.Query().Get("state")))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+		return
+	}
+
+	var draftData DraftFields
+	err := json.NewDecoder(r.Body).Decode(&draftData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Invalid draft"))
+		return
+	}
+	draftData.Author = user
+	now := int(time.Now().UnixMilli())
+
+	switch r.Method {
+	case "POST", "PUT":
+		if r.Method == "POST" {
+			draftData.Id = Generate()
+			draftData.Created = now
+		} else if GetDraft(draftData.Id).Author != user {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(JsonMessage("draft not found"))
+			return
+		}
+		draftData.Updated = now
+		draftData.State = "draft"
+		if draftData.PublishAt != 0 {
+			if draftData.PublishAt <= now {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write(JsonMessage("publish-at must be in the future"))
+				return
+			}
+			draftData.State = "scheduled"
+		}
+		if problem := DraftError(draftData); problem != "" {
+			draftData.Error = problem
+			w.WriteHeader(http.StatusBadRequest)
+			content, _ := json.Marshal(draftData)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(content)
+			return
+		}
+		if r.Method == "POST" {
+			err = AddDraft(draftData)
+		} else {
+			err = UpdateDraft(draftData)
+		}
+		if err == sql.ErrNoRows {
+			w.WriteHeader(http.StatusConflict)
+			w.Write(JsonMessage("This post is already being published"))
+			return
+		}
+	case "DELETE":
+		err = RemoveDraft(draftData.Id, user)
+		if err == sql.ErrNoRows {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(JsonMessage("draft not found"))
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(JsonMessage("Please Try Again Later"))
+		return
+	}
+	if r.Method != "DELETE" {
+		draftData = GetDraft(draftData.Id)
+	}
+	content, _ := json.Marshal(draftData)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// This endpoint lists the reactions that can be used (GET) and sets the logged in user's reaction
+// to a post, comment, group post or group comment (POST). Sending the reaction already set removes it.
+// Responds with the item and its updated reaction counts.
+func ReactionsApi(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		content, _ := json.Marshal(ReactionTypes)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+		return
+	}
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	user := LoggedInUser(r).Nickname
+	if user == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(JsonMessage("unauthorized"))
+		return
+	}
+	var reactionData ReactionFields
+	err := json.NewDecoder(r.Body).Decode(&reactionData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Invalid reaction"))
+		return
+	}
+	if !Contains(ReactionTypes, ReactionName(reactionData.Reaction)) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("reaction must be one of " + strings.Join(ReactionTypes, ", ")))
+		return
+	}
+	if !Contains(reactionTargets, reactionData.TargetType) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("target-type must be post, comment, group-post or group-comment"))
+		return
+	}
+	if _, ok := reactionTarget(reactionData.TargetType, reactionData.TargetId, user); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(JsonMessage(reactionData.TargetType + " not found"))
+		return
+	}
+	if AddReaction(reactionData.TargetType, reactionData.TargetId, user, reactionData.Reaction) != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(JsonMessage("Please Try Again Later"))
+		return
+	}
+	item, _ := reactionTarget(reactionData.TargetType, reactionData.TargetId, user)
+	content, _ := json.Marshal(item)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// Get a post, comment, group post or group comment and whether user may react to it or vote
+// in its poll. Comments follow their post.
+func reactionTarget(targetType, targetId, user string) (interface{}, bool) {
+	switch targetType {
+	case "post":
+		post := GetPost(targetId, user)
+		return post, CanInteract(user, post)
+	case "comment":
+		comment := GetComment(targetId, user)
+		return comment, comment.CommentId != "" && CanInteract(user, GetPost(comment.PostId, user))
+	case "group-post":
+		post := GetGroupPost(targetId, user)
+		return post, CanInteract(user, post)
+	case "group-comment":
+		comment := GetGroupPostComment(targetId, user)
+		return comment, comment.CommentId != "" && CanInteract(user, GetGroupPost(comment.PostId, user))
+	}
+	return nil, false
+}
+
+// Whether user may see a post, comment, group post or group comment, logged in or not.
+// Comments follow their post.
+func viewTarget(targetType, targetId, user string) bool {
+	switch targetType {
+	case "post":
+		return CanView(user, GetPost(targetId, user))
+	case "comment":
+		comment := GetComment(targetId, user)
+		return comment.CommentId != "" && CanView(user, GetPost(comment.PostId, user))
+	case "group-post":
+		return CanView(user, GetGroupPost(targetId, user))
+	case "group-comment":
+		comment := GetGroupPostComment(targetId, user)
+		return comment.CommentId != "" && CanView(user, GetGroupPost(comment.PostId, user))
+	}
+	return false
+}
+
+// This endpoint returns a poll with its results (GET ?poll-id=) and casts the logged in user's
+// ballot (POST). Users vote once per poll; everyone watching the poll gets the new results live.
+func PollsApi(w http.ResponseWriter, r *http.Request) {
+	user := LoggedInUser(r).Nickname
+	switch r.Method {
+	case "GET":
+		poll, ok := GetPoll(r.URL.Query().Get("poll-id"), user)
+		if !ok || !viewTarget(poll.TargetType, poll.TargetId, user) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(JsonMessage("poll not found"))
+			return
+		}
+		content, _ := json.Marshal(poll)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+	case "POST":
+		if user == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write(JsonMessage("unauthorized"))
+			return
+		}
+		var ballot BallotFields
+		err := json.NewDecoder(r.Body).Decode(&ballot)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(JsonMessage("Invalid ballot"))
+			return
+		}
+		poll, ok := GetPoll(ballot.PollId, user)
+		if _, canVote := reactionTarget(poll.TargetType, poll.TargetId, user); !ok || !canVote {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(JsonMessage("poll not found"))
+			return
+		}
+		err = Vote(ballot.PollId, user, ballot.Options)
+		switch err {
+		case nil:
+		case errPollClosed:
+			w.WriteHeader(http.StatusConflict)
+			w.Write(JsonMessage("This poll is closed"))
+			return
+		case errAlreadyVoted:
+			w.WriteHeader(http.StatusConflict)
+			w.Write(JsonMessage("You already voted in this poll"))
+			return
+		case errInvalidBallot:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(JsonMessage("please choose one of the poll's options"))
+			return
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(JsonMessage("Please Try Again Later"))
+			return
+		}
+		BroadcastPoll(ballot.PollId)
+		poll, _ = GetPoll(ballot.PollId, user)
+		content, _ := json.Marshal(poll)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// This endpoint lists the stories the logged in user can see grouped by author (GET), posts a story
+// (POST) and removes one of the user's own stories (DELETE ?story-id=). Stories expire after a day.
+func StoriesApi(w http.ResponseWriter, r *http.Request) {
+	user := LoggedInUser(r)
+	if user.Nickname == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(JsonMessage("unauthorized"))
+		return
+	}
+	switch r.Method {
+	case "GET":
+		content, _ := json.Marshal(GetStories(user))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+	case "POST":
+		var storyData StoryFields
+		err := json.NewDecoder(r.Body).Decode(&storyData)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(JsonMessage("Invalid story"))
+			return
+		}
+		storyData.Author = user.Nickname
+		if message := StoryError(storyData); message != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(JsonMessage(message))
+			return
+		}
+		story, err := AddStory(storyData)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(JsonMessage("Please Try Again Later"))
+			return
+		}
+		content, _ := json.Marshal(GetStory(story.Id, user.Nickname))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+	case "DELETE":
+		story := GetStory(r.URL.Query().Get("story-id"), user.Nickname)
+		if story.Id == "" || story.Author != user.Nickname {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(JsonMessage("story not found"))
+			return
+		}
+		if RemoveStory(story.Id) != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(JsonMessage("Please Try Again Later"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(JsonMessage("Story removed"))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// This endpoint marks a story as seen by the logged in user (POST {"story-id"}) and
+// lists who has seen it to its author (GET ?story-id=).
+func StoryViewsApi(w http.ResponseWriter, r *http.Request) {
+	user := LoggedInUser(r).Nickname
+	storyId := r.URL.Query().Get("story-id")
+	if r.Method == "POST" {
+		var storyData StoryFields
+		err := json.NewDecoder(r.Body).Decode(&storyData)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(JsonMessage("Invalid story"))
+			return
+		}
+		storyId = storyData.Id
+	}
+	story := GetStory(storyId, user)
+	if story.Id == "" {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(JsonMessage("story not found"))
+		return
+	}
+	switch r.Method {
+	case "GET":
+		if story.Author != user {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write(JsonMessage("only the author can see who viewed a story"))
+			return
+		}
+		content, _ := json.Marshal(GetStoryViewers(story.Id))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+	case "POST":
+		if MarkStorySeen(story, user) != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(JsonMessage("Please Try Again Later"))
+			return
+		}
+		content, _ := json.Marshal(GetStory(story.Id, user))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// This endpoint reposts or quotes a post the user can see (POST) and undoes a repost (DELETE).
+// Reposts keep the visibility of the original on top of their own privacy.
+func RepostsApi(w http.ResponseWriter, r *http.Request) {
+	user := LoggedInUser(r).Nickname
+	if user == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(JsonMessage("unauthorized"))
+		return
+	}
+	var repostData RepostFields
+	err := json.NewDecoder(r.Body).Decode(&repostData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Invalid repost"))
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		original := GetPost(repostData.OriginalId, user)
+		if original.Id == "" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(JsonMessage("post not found"))
+			return
+		}
+		// Reposting a repost shares the post it points to.
+		if original.Kind == "repost" && original.Original != nil && !original.OriginalDeleted {
+			original = *original.Original
+		}
+		if repostData.Kind != "repost" && repostData.Kind != "quote" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(JsonMessage("post-kind must be repost or quote"))
+			return
+		}
+		if repostData.Kind == "quote" && repostData.Text == "" && repostData.Image == "" && repostData.Thread == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(JsonMessage("please add content to your quote"))
+			return
+		}
+		if repostData.Kind == "repost" {
+			if GetUserRepost(user, original.Id) != "" {
+				w.WriteHeader(http.StatusConflict)
+				w.Write(JsonMessage("You already reposted this post"))
+				return
+			}
+			repostData.Image, repostData.Text, repostData.Thread = "", "", ""
+		}
+		if repostData.Privacy == "" {
+			repostData.Privacy = "public"
+		}
+		if !Contains(postPrivacies, repostData.Privacy) || (repostData.Privacy == "audience" && GetAudience(repostData.Viewers).Owner != user) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(JsonMessage("please choose who can see the post"))
+			return
+		}
+		repost := PostFields{
+			Id:       Generate(),
+			Author:   user,
+			Image:    repostData.Image,
+			Text:     repostData.Text,
+			Thread:   repostData.Thread,
+			Time:     int(time.Now().UnixMilli()),
+			Privacy:  repostData.Privacy,
+			Viewers:  repostData.Viewers,
+			Kind:     repostData.Kind,
+			Original: &original,
+		}
+		if AddRepost(repost, original.Id, repostData.Kind) != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(JsonMessage("Please Try Again Later"))
+			return
+		}
+		content, _ := json.Marshal(GetPost(repost.Id, user))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+	case "DELETE":
+		// The original is not looked up: a repost can be undone after the original is removed or hidden.
+		repostId := GetUserRepost(user, repostData.OriginalId)
+		if repostId == "" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(JsonMessage("repost not found"))
+			return
+		}
+		if RemovePost(repostId) != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(JsonMessage("Please Try Again Later"))
+			return
+		}
+		content, _ := json.Marshal(GetPost(repostData.OriginalId, user))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// This endpoint lists the logged in user's saved items (GET ?collection=&cursor=&limit=),
+// saves a visible post or group post (POST) and removes a saved item (DELETE).
+// A collection-id of "*" on DELETE removes the item from every collection.
+func BookmarksApi(w http.ResponseWriter, r *http.Request) {
+	user := LoggedInUser(r).Nickname
+	if user == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(JsonMessage("unauthorized"))
+		return
+	}
+	if r.Method == "GET" {
+		collection := r.URL.Query().Get("collection")
+		if collection != "" && GetCollection(collection).Owner != user {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(JsonMessage("Collection not found"))
+			return
+		}
+		page, err := GetBookmarks(user, collection, r.URL.Query().Get("cursor"), PageLimit(r, 20, 50))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(JsonMessage("Invalid cursor"))
+			return
+		}
+		content, _ := json.Marshal(page)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+		return
+	}
+
+	var bookmarkData BookmarkFields
+	if err := json.NewDecoder(r.Body).Decode(&bookmarkData); err != nil || !Contains(bookmarkTypes, bookmarkData.Type) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Please choose a post or group-post to save"))
+		return
+	}
+	if bookmarkData.Collection != "" && bookmarkData.Collection != "*" && GetCollection(bookmarkData.Collection).Owner != user {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(JsonMessage("Collection not found"))
+		return
+	}
+
+	var err error
+	switch r.Method {
+	case "POST":
+		visible := false
+		if bookmarkData.Type == "post" {
+			visible = GetPost(bookmarkData.Id, user).Id != ""
+		} else {
+			visible = GetGroupPost(bookmarkData.Id, user).PostId != ""
+		}
+		if !visible || bookmarkData.Collection == "*" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(JsonMessage("post not found"))
+			return
+		}
+		bookmarkData.Created = int(time.Now().UnixMilli())
+		err = AddBookmark(user, bookmarkData)
+	case "DELETE":
+		err = RemoveBookmark(user, bookmarkData)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		bookmarkData.Error = "Please Try Again Later"
+	}
+	content, _ := json.Marshal(bookmarkData)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// This endpoint lists (GET), creates or renames (POST) and removes (DELETE) bookmark collections.
+func CollectionsApi(w http.ResponseWriter, r *http.Request) {
+	user := LoggedInUser(r).Nickname
+	if user == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(JsonMessage("unauthorized"))
+		return
+	}
+	if r.Method == "GET" {
+		content, _ := json.Marshal(GetCollections(user))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+		return
+	}
+
+	var collectionData CollectionFields
+	if err := json.NewDecoder(r.Body).Decode(&collectionData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Invalid collection"))
+		return
+	}
+	if collectionData.Id != "" && GetCollection(collectionData.Id).Owner != user {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(JsonMessage("Collection not found"))
+		return
+	}
+
+	var err error
+	switch r.Method {
+	case "POST":
+		collectionData.Owner = user
+		collectionData.Name = strings.TrimSpace(collectionData.Name)
+		if collectionData.Name == "" || collectionData.Name == "*" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(JsonMessage("Please name the collection"))
+			return
+		}
+		collectionData, err = SaveCollection(collectionData)
+		if err != nil && strings.Contains(err.Error(), "UNIQUE") {
+			w.WriteHeader(http.StatusConflict)
+			w.Write(JsonMessage("You already have a collection with this name"))
+			return
+		}
+	case "DELETE":
+		if collectionData.Id == "" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(JsonMessage("Collection not found"))
+			return
+		}
+		err = RemoveCollection(collectionData.Id)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		collectionData.Error = "Please Try Again Later"
+	}
+	content, _ := json.Marshal(collectionData)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// This endpoint searches users by nickname, first and last name. GET /api/users/search?q=&cursor=&limit=
+func SearchUsersApi(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	viewer := LoggedInUser(r)
+	if viewer.Nickname == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(JsonMessage("unauthorized"))
+		return
+	}
+
+	page, err := SearchUsers(viewer, r.URL.Query().Get("q"), r.URL.Query().Get("cursor"), PageLimit(r, 20, 50))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Invalid cursor"))
+		return
+	}
+	content, _ := json.Marshal(page)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
 // This api helper function checks whether the current user is following the followee.
 func FollowersApi(w http.ResponseWriter, r *http.Request) {
 
@@ -38,6 +988,53 @@ func FollowersApi(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// This endpoint removes one of the logged in user's followers. POST /api/followers/remove {"follower": nickname}
+// Both users get the new counts, but the removed follower gets no notification about it.
+func RemoveFollowerApi(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	user := LoggedInUser(r)
+	if user.Nickname == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(JsonMessage("unauthorized"))
+		return
+	}
+	var removeData struct {
+		Follower string `json:"follower"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&removeData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Invalid follower"))
+		return
+	}
+	follower := GetUserByNickname(removeData.Follower)
+	if follower.Nickname == "" {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(JsonMessage("Follower not found"))
+		return
+	}
+
+	followers, following, err := RemoveFollower(follower.Email, user.Email)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(JsonMessage("Follower not found"))
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(JsonMessage("Please Try Again Later"))
+		return
+	}
+
+	updateMsg := followNotification{UpdateUser: user.Email, Followers: followers, FollowerFollowingCount: following}
+	H.SendTo([]string{user.Nickname, follower.Nickname}, updateMsg)
+	content, _ := json.Marshal(updateMsg)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
 // This endpoint returns all followers.
 func AllFollowersApi(w http.ResponseWriter, r *http.Request) {
 
@@ -49,6 +1046,14 @@ func AllFollowersApi(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("Error in FollowersApi function:  ", err)
 	}
 
+	// Private accounts only share their lists with their followers.
+	target := GetUserFromFollowMessage(user.Email)
+	if !CanViewFollowLists(LoggedInUser(r), target) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(JsonMessage("This account is private"))
+		return
+	}
+
 	// Try to find rows where follower=user.Email or followee=user.Email, in order to see who is following when clicking on the follower/following count.
 	bytes := ExecuteSQL(`SELECT * FROM followers WHERE follower="` + user.Email + `" OR followee="` + user.Email + `";`)
 
@@ -81,6 +1086,377 @@ func AllFollowersApi(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// This endpoint lists (GET), adds (POST) and removes (DELETE) the logged in user's mutes.
+func MutesApi(w http.ResponseWriter, r *http.Request) {
+	user := LoggedInUser(r).Nickname
+	if user == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(JsonMessage("unauthorized"))
+		return
+	}
+
+	if r.Method == "GET" {
+		content, _ := json.Marshal(GetMutes(user))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+		return
+	}
+
+	var muteData MuteFields
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&muteData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Invalid mute"))
+		return
+	}
+	muteData.User = user
+	muteData = NormaliseMute(muteData)
+	if !Contains(muteTypes, muteData.Type) || muteData.Muted == "" || muteData.Muted == "#" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Please choose a user, word or hashtag to mute"))
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		if muteData.Type == "user" {
+			if muteData.Muted == user {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write(JsonMessage("You cannot mute yourself"))
+				return
+			}
+			db := OpenDB()
+			row, err := PreparedQuery("SELECT * FROM users WHERE nickname = ?", muteData.Muted, db, "MutesApi")
+			mutedUser := QueryUser(row, err)
+			db.Close()
+			if mutedUser.Nickname == "" {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write(JsonMessage("User not found"))
+				return
+			}
+		}
+		if muteData.Expires != 0 && int64(muteData.Expires) <= time.Now().UnixMilli() {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(JsonMessage("Mute expiry must be in the future"))
+			return
+		}
+		err = AddMute(muteData)
+	case "DELETE":
+		err = RemoveMute(muteData)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		muteData.Error = "Please Try Again Later"
+	}
+	content, _ := json.Marshal(muteData)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// This endpoint returns the presence of a list of users (GET /api/presence?users=a,b)
+// and updates whether the logged in user hides their presence (POST).
+func PresenceApi(w http.ResponseWriter, r *http.Request) {
+	viewer := LoggedInUser(r)
+	if viewer.Nickname == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(JsonMessage("unauthorized"))
+		return
+	}
+
+	if r.Method == "POST" {
+		var settings PresenceSettings
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&settings); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(JsonMessage("Invalid presence settings"))
+			return
+		}
+		if err := UpdatePresenceSettings(viewer.Nickname, settings); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(JsonMessage("Please Try Again Later"))
+			return
+		}
+		content, _ := json.Marshal(settings)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+		return
+	}
+
+	// Presence is only shared with followers and chat partners, like the realtime events.
+	chatPartners := GetChatPartners(viewer.Nickname)
+	following, _ := GetFollowSets(viewer.Email)
+	var names []string
+	for _, name := range strings.Split(r.URL.Query().Get("users"), ",") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	online := H.Presence(names)
+	sliceOfPresence := []presenceMessage{}
+	for _, name := range names {
+		presence := presenceMessage{User: name, Status: "offline"}
+		db := OpenDB()
+		row, err := PreparedQuery("SELECT * FROM users WHERE nickname = ?", name, db, "PresenceApi")
+		user := QueryUser(row, err)
+		db.Close()
+		visible := user.Nickname == viewer.Nickname || following[user.Email] || Contains(chatPartners, name)
+		if user.Nickname != "" && visible && (user.Nickname == viewer.Nickname || !GetPresenceSettings(name).Hidden) {
+			presence.Status = online[name]
+			presence.LastSeen = GetLastSeen(name)
+		}
+		sliceOfPresence = append(sliceOfPresence, presence)
+	}
+	content, _ := json.Marshal(sliceOfPresence)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// This endpoint returns the cached "people you may know" suggestions. GET /api/suggestions?limit=
+func SuggestionsApi(w http.ResponseWriter, r *http.Request) {
+	viewer := LoggedInUser(r)
+	if viewer.Nickname == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(JsonMessage("unauthorized"))
+		return
+	}
+	content, _ := json.Marshal(GetSuggestions(viewer, PageLimit(r, 10, maxSuggestions)))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// Follow requests.
+// GET  /api/follow-requests?direction=incoming|outgoing&state=pending lists requests.
+// POST /api/follow-requests {"target"} sends a request, following straight away if the account is public.
+// POST /api/follow-requests/accept, /decline or /cancel {"request-id"} moves a pending request.
+func FollowRequestsApi(w http.ResponseWriter, r *http.Request) {
+	user := LoggedInUser(r)
+	if user.Nickname == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(JsonMessage("unauthorized"))
+		return
+	}
+
+	if r.Method == "GET" {
+		direction := r.URL.Query().Get("direction")
+		if direction != "outgoing" {
+			direction = "incoming"
+		}
+		state := r.URL.Query().Get("state")
+		if state == "" {
+			state = "pending"
+		}
+		content, _ := json.Marshal(GetFollowRequests(user.Nickname, direction, state))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+		return
+	} else if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestData FollowRequestFields
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&requestData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Invalid follow request"))
+		return
+	}
+
+	action := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/follow-requests"), "/")
+	if action == "" {
+		target := GetUserByNickname(requestData.Target)
+		if target.Nickname == "" || target.Nickname == user.Nickname {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(JsonMessage("User not found"))
+			return
+		}
+		following, _ := GetFollowSets(user.Email)
+		if following[target.Email] {
+			w.WriteHeader(http.StatusConflict)
+			w.Write(JsonMessage("Already following"))
+			return
+		}
+		request, err := AddFollowRequest(user.Nickname, target.Nickname)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(JsonMessage("Please Try Again Later"))
+			return
+		}
+		if target.Status != "private" {
+			AcceptFollowRequest(request)
+		} else if !GetRequestNotif(target.Nickname, user.Nickname, "followRequest", "") {
+			AddRequestNotif(user.Nickname, target.Nickname, "followRequest", "")
+			followData := followMessage{
+				FollowRequest:         user.Email,
+				ToFollow:              target.Email,
+				Followers:             target.Followers,
+				FollowRequestUsername: user.Nickname,
+				FolloweeUsername:      target.Nickname,
+			}
+			if !IsMutedContent(target.Nickname, user.Nickname, "", "") {
+				H.SendTo([]string{target.Nickname}, RequestNotifcationFields{FollowRequest: followData})
+			}
+		}
+		content, _ := json.Marshal(GetFollowRequest(request.Id))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+		return
+	}
+
+	state, ok := followRequestTransitions[action]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	request := GetFollowRequest(requestData.Id)
+	// Only the target answers a request and only the requester cancels it.
+	if (action == "cancel" && request.Requester != user.Nickname) || (action != "cancel" && request.Target != user.Nickname) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(JsonMessage("Follow request not found"))
+		return
+	}
+	var moved bool
+	if state == "accepted" {
+		moved = AcceptFollowRequest(request)
+	} else if moved = UpdateFollowRequestState(request.Id, state); moved {
+		SqlExec.RequestNotificationData <- RequestNotifcationFields{Sender: request.Requester, Receiver: request.Target}
+	}
+	if !moved {
+		w.WriteHeader(http.StatusConflict)
+		w.Write(JsonMessage("Follow request is no longer pending"))
+		return
+	}
+	content, _ := json.Marshal(GetFollowRequest(request.Id))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// This endpoint pages through who follows a user, or who they follow.
+// GET /api/users/followers?user=&cursor=&limit= and GET /api/users/following?user=&cursor=&limit=
+func FollowListApi(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	viewer := LoggedInUser(r)
+	if viewer.Nickname == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(JsonMessage("unauthorized"))
+		return
+	}
+	target := viewer
+	if nickname := r.URL.Query().Get("user"); nickname != "" {
+		target = GetUserByNickname(nickname)
+	}
+	if target.Nickname == "" {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(JsonMessage("User not found"))
+		return
+	}
+	if !CanViewFollowLists(viewer, target) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(JsonMessage("This account is private"))
+		return
+	}
+
+	list := strings.TrimPrefix(r.URL.Path, "/api/users/")
+	page, err := GetFollowList(viewer, target, list, r.URL.Query().Get("cursor"), PageLimit(r, 20, 100))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Invalid cursor"))
+		return
+	}
+	content, _ := json.Marshal(page)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// This endpoint manages the logged in user's audience lists. GET lists them with their members,
+// POST adds one or, given an audience-id, renames it and replaces its members, DELETE removes one.
+func AudiencesApi(w http.ResponseWriter, r *http.Request) {
+	user := LoggedInUser(r).Nickname
+	if user == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(JsonMessage("unauthorized"))
+		return
+	}
+	if r.Method == "GET" {
+		content, _ := json.Marshal(GetAudiences(user))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+		return
+	}
+
+	var audienceData AudienceFields
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&audienceData); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Invalid audience"))
+		return
+	}
+	current := AudienceFields{Owner: user}
+	if audienceData.Id != "" {
+		current = GetAudience(audienceData.Id)
+		if current.Owner != user {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(JsonMessage("Audience not found"))
+			return
+		}
+	}
+
+	var err error
+	switch r.Method {
+	case "POST":
+		audienceData.Owner = user
+		audienceData.BuiltIn = current.BuiltIn
+		audienceData.Name = strings.TrimSpace(audienceData.Name)
+		if current.BuiltIn {
+			audienceData.Name = current.Name
+		}
+		if audienceData.Name == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(JsonMessage("Please name the audience"))
+			return
+		}
+		var members []string
+		for _, member := range audienceData.Members {
+			if member != user && !Contains(members, member) {
+				if GetUserByNickname(member).Nickname == "" {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write(JsonMessage("User not found: " + member))
+					return
+				}
+				members = append(members, member)
+			}
+		}
+		audienceData.Members = members
+		audienceData, err = SaveAudience(audienceData)
+		if err == nil {
+			audienceData = GetAudience(audienceData.Id)
+		}
+	case "DELETE":
+		if current.BuiltIn {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(JsonMessage("Close friends cannot be removed"))
+			return
+		}
+		err = RemoveAudience(current.Id)
+		audienceData = current
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		audienceData.Error = "Please Try Again Later"
+	}
+	content, _ := json.Marshal(audienceData)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
 func createApi(table string, w http.ResponseWriter, r *http.Request) {
 
 	// fmt.Println(table)
@@ -126,6 +1502,11 @@ func UpdateUserStatus(w http.ResponseWriter, r *http.Request) {
 	PreparedExec("UPDATE users SET status=? WHERE email=?", data, db, "UpdateUserStatus")
 
 	db.Close()
+
+	// Nobody needs approval to follow a public account.
+	if request.SetStatus == "public" {
+		AcceptPendingFollowRequests(GetUserFromFollowMessage(request.User).Nickname)
+	}
 }
 
 // Function that queryies database and returns list of bytes to unmarshal.
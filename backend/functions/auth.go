@@ -256,6 +256,7 @@ func CreateUser(newUser User) error {
 
 	// Ppdate password to password hash.
 	newUser.Password = passwordHash
+	newUser.Avatar = StoreDataUrl(newUser.Avatar, newUser.Nickname)
 
 	// Try to insert user into database.
 	_, err2 := db.Exec("INSERT INTO users(email, password, firstname, lastname, dob, avatar, nickname, aboutme, status) values(?,?,?,?,?,?,?,?,?)", newUser.Email, newUser.Password, newUser.Firstname, newUser.Lastname, newUser.DOB, newUser.Avatar, newUser.Nickname, newUser.Aboutme, newUser.Status)
@@ -498,10 +499,28 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.Write(content)
 
+	} else if postData.Privacy == "audience" && GetAudience(postData.Viewers).Owner != user {
+		postData.Error = "please choose one of your audiences"
+		content, _ := json.Marshal(postData)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+
+	} else if postData.Poll != nil && PollError(*postData.Poll) != "" {
+		postData.Error = PollError(*postData.Poll)
+		content, _ := json.Marshal(postData)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+
 	} else {
 		postData.Id = Generate()
 		postData.Author = user
-		AddPost(postData)
+		if AddPost(postData) != nil {
+			postData.Error = "Could Not Add Post, Please Try Again Later"
+			content, _ := json.Marshal(postData)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(content)
+			return
+		}
 		content, _ := json.Marshal("Post Added Successfully")
 		w.Header().Set("Content-Type", "application/json")
 		w.Write(content)
@@ -524,9 +543,20 @@ func PostInteractions(w http.ResponseWriter, r *http.Request) {
 			panic(err)
 		}
 		user := LoggedInUser(r).Nickname
+		// Viewing comments only needs the post to be visible, everything else needs a logged in user.
+		post := GetPost(likeData.PostId, user)
+		if post.Id == "" || (likeData.Type != "comments" && !CanInteract(user, post)) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(JsonMessage("post not found"))
+			return
+		}
 		if likeData.Type == "like/dislike" {
-			likeData.Username = user
-			err := AddPostLikes(likeData)
+			err := AddReaction("post", likeData.PostId, user, likeData.Like)
+			if err == errUnknownReaction {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write(JsonMessage("reaction must be one of " + strings.Join(ReactionTypes, ", ")))
+				return
+			}
 			if err != nil {
 				postLikes := GetPost(likeData.PostId, user)
 				postLikes.Error = "Please Try Again Later"
@@ -560,8 +590,9 @@ func PostInteractions(w http.ResponseWriter, r *http.Request) {
 
 			}
 		} else if likeData.Type == "comments" {
-			commentData := GetPostComments(likeData.PostId, user)
-			content, _ := json.Marshal(commentData)
+			// The first page of comments, /api/comments returns the rest.
+			page, _ := GetComments("comment", likeData.PostId, user, "oldest", "", CommentPageSize)
+			content, _ := json.Marshal(page.Comments)
 			w.Header().Set("Content-Type", "application/json")
 			w.Write(content)
 
@@ -586,6 +617,11 @@ func EditPost(w http.ResponseWriter, r *http.Request) {
 		}
 		user := LoggedInUser(r).Nickname
 		currentPost := GetPost(postData.Id, user)
+		if currentPost.Id == "" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(JsonMessage("post not found"))
+			return
+		}
 		if user == "" {
 			postData.Error = "Cannot Edit Post, please Sign Up or Log In"
 
@@ -606,6 +642,7 @@ func EditPost(w http.ResponseWriter, r *http.Request) {
 			if postData.Image == "" {
 				postData.Image = currentPost.Image
 			}
+			postData.Author = currentPost.Author
 			err = UpdatePost(postData)
 			if err != nil {
 				postData.Error = "Error Editing Post please try again later"
@@ -642,6 +679,22 @@ func CreateComment(w http.ResponseWriter, r *http.Request) {
 		}
 		fmt.Println("check comment-id", commentData)
 		user := LoggedInUser(r).Nickname
+		if !CanInteract(user, GetPost(commentData.PostId, user)) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(JsonMessage("post not found"))
+			return
+		}
+		// Replies must answer a comment on the same post.
+		if commentData.ParentId != "" && GetComment(commentData.ParentId, user).PostId != commentData.PostId {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(JsonMessage("comment not found"))
+			return
+		}
+		if message := CommentError(commentData); message != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(JsonMessage(message))
+			return
+		}
 		commentData.CommentId = Generate()
 		commentData.Author = user
 		AddCommentErr := AddComment(commentData)
@@ -652,9 +705,11 @@ func CreateComment(w http.ResponseWriter, r *http.Request) {
 			w.Write(content)
 
 		} else {
+			page, _ := GetComments("comment", commentData.PostId, user, "oldest", "", CommentPageSize)
 			allComments := ReturnComments{
-				TotalComments: GetPostComments(commentData.PostId, user),
-				Post:          GetPost(commentData.PostId, user),
+				TotalComments:  page.Comments,
+				CommentsCursor: page.NextCursor,
+				Post:           GetPost(commentData.PostId, user),
 			}
 			content, _ := json.Marshal(allComments)
 			w.Header().Set("Content-Type", "application/json")
@@ -681,9 +736,18 @@ func CommentInteractions(w http.ResponseWriter, r *http.Request) {
 
 		user := LoggedInUser(r).Nickname
 		comment := GetComment(likeData.CommentId, user)
+		if comment.CommentId == "" || !CanInteract(user, GetPost(comment.PostId, user)) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(JsonMessage("comment not found"))
+			return
+		}
 		if likeData.Type == "like/dislike" {
-			likeData.Username = user
-			err := AddCommentLike(likeData)
+			err := AddReaction("comment", likeData.CommentId, user, likeData.Like)
+			if err == errUnknownReaction {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write(JsonMessage("reaction must be one of " + strings.Join(ReactionTypes, ", ")))
+				return
+			}
 			if err != nil {
 				postLikes := GetComment(likeData.CommentId, user)
 				postLikes.Error = "Please Try Again Later"
@@ -700,6 +764,14 @@ func CommentInteractions(w http.ResponseWriter, r *http.Request) {
 			}
 
 		} else if likeData.Type == "delete" {
+			if !CanDeleteComment(user, "comment", comment) {
+				comment.Error = "you cannot delete this comment"
+				content, _ := json.Marshal(comment)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				w.Write(content)
+				return
+			}
 			err = RemoveComment(likeData.CommentId)
 			if err != nil {
 				comment.Error = "Error Deleting Comment please try again later"
@@ -717,6 +789,61 @@ func CommentInteractions(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 }
+func EditComment(w http.ResponseWriter, r *http.Request) {
+	editTypedComment(w, r, "comment")
+}
+
+// Edit a comment of targetType ("comment" or "group-comment") and return it as it now is,
+// or with its Error set when the edit was refused or failed.
+func editTypedComment(w http.ResponseWriter, r *http.Request, targetType string) {
+	var commentData CommentFields
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		panic(err)
+	}
+	err = json.Unmarshal(body, &commentData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Invalid comment"))
+		return
+	}
+	user := LoggedInUser(r).Nickname
+	currentComment := getTypedComment(targetType, commentData.CommentId, user)
+	if currentComment.CommentId == "" || user == "" || !commentPostVisible(targetType, currentComment.PostId, user) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(JsonMessage("comment not found"))
+		return
+	}
+	if message := CommentError(commentData); message != "" {
+		commentData.Error = message
+	} else if !CanEditComment(user, currentComment) {
+		commentData.Error = "you are NOT the author"
+	} else {
+		if commentData.Image == "" {
+			commentData.Image = currentComment.Image
+		}
+		commentData.Author = currentComment.Author
+		if updateTypedComment(targetType, commentData) != nil {
+			commentData.Error = "Error Editing Comment please try again later"
+		}
+	}
+	if commentData.Error != "" {
+		content, _ := json.Marshal(commentData)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+		return
+	}
+	// get that specific comment and return
+	content, _ := json.Marshal(getTypedComment(targetType, commentData.CommentId, user))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
 func GetAllGroups(w http.ResponseWriter, r *http.Request) {
 	// get all groups that you re not involved in
 	user := LoggedInUser(r).Nickname
@@ -806,7 +933,7 @@ func SendGroupRequest(w http.ResponseWriter, r *http.Request) {
 		adminSess := H.user[admin]
 		if !GetRequestNotif(admin, user, "send-group-request", groupId) {
 			AddRequestNotif(user, admin, "send-group-request", groupId)
-			if len(adminSess) != 0 {
+			if len(adminSess) != 0 && !IsMutedContent(admin, user, "", "") {
 				for userSub := range adminSess {
 					userSub.conn.send <- message{incomingData: RequestNotifcationFields{GroupAction: GroupAcceptNotification{
 						User:        user,
@@ -843,6 +970,9 @@ func AddMemberToGroup(w http.ResponseWriter, r *http.Request) {
 			admin := group.Admin
 			adminSess := H.user[admin]
 			if len(adminSess) != 0 {
+				if IsMutedContent(admin, user, "", "") {
+					return
+				}
 				for userSub := range adminSess {
 					userSub.conn.send <- message{incomingData: RequestNotifcationFields{GroupAction: GroupAcceptNotification{
 						User:        user,
@@ -910,6 +1040,11 @@ func GroupPosts(w http.ResponseWriter, r *http.Request) {
 
 	groupId := string(body)
 	user := LoggedInUser(r).Nickname
+	if !CanView(user, GetGroup(groupId)) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(JsonMessage("group not found"))
+		return
+	}
 	go func() {
 		groupRoomId <- groupId
 	}()
@@ -949,6 +1084,12 @@ func CreateGroupPost(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "application/json")
 			w.Write(content)
 
+		} else if postData.Poll != nil && PollError(*postData.Poll) != "" {
+			postData.Error = PollError(*postData.Poll)
+			content, _ := json.Marshal(postData)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(content)
+
 		} else {
 			postData.PostId = Generate()
 			postData.Author = user
@@ -963,17 +1104,7 @@ func CreateGroupPost(w http.ResponseWriter, r *http.Request) {
 
 				} else {
 					// get all posts and return
-					group := GetGroup(postData.Id)
-					potentialMember := strings.Split(group.Users, ",")
-					for _, member := range potentialMember {
-						if member != user {
-							loggedInMember := H.user[member]
-							for userSub := range loggedInMember {
-								postData.Group = group
-								userSub.conn.send <- message{incomingData: postData}
-							}
-						}
-					}
+					BroadcastGroupPost(postData)
 					allPosts := GetGroupPosts(user, postData.Id)
 					content, _ := json.Marshal(allPosts[len(allPosts)-1])
 					w.Header().Set("Content-Type", "application/json")
@@ -1007,9 +1138,18 @@ func GroupPostInteractions(w http.ResponseWriter, r *http.Request) {
 			panic(err)
 		}
 		user := LoggedInUser(r).Nickname
+		if !CanInteract(user, GetGroupPost(likeData.PostId, user)) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(JsonMessage("post not found"))
+			return
+		}
 		if likeData.Type == "like/dislike" {
-			likeData.Username = user
-			err := AddGroupLike(likeData)
+			err := AddReaction("group-post", likeData.PostId, user, likeData.Like)
+			if err == errUnknownReaction {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write(JsonMessage("reaction must be one of " + strings.Join(ReactionTypes, ", ")))
+				return
+			}
 			if err != nil {
 				postLikes := GetGroupPost(likeData.PostId, user)
 				postLikes.Error = "Please Try Again Later"
@@ -1044,8 +1184,9 @@ func GroupPostInteractions(w http.ResponseWriter, r *http.Request) {
 
 			}
 		} else if likeData.Type == "comments" {
-			commentData := GetGroupPostComments(likeData.PostId, user)
-			content, _ := json.Marshal(commentData)
+			// The first page of comments, /api/comments returns the rest.
+			page, _ := GetComments("group-comment", likeData.PostId, user, "oldest", "", CommentPageSize)
+			content, _ := json.Marshal(page.Comments)
 			w.Header().Set("Content-Type", "application/json")
 			w.Write(content)
 		}
@@ -1069,6 +1210,11 @@ func EditGroupPost(w http.ResponseWriter, r *http.Request) {
 		}
 		user := LoggedInUser(r).Nickname
 		currentPost := GetGroupPost(postData.PostId, user)
+		if currentPost.PostId == "" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(JsonMessage("post not found"))
+			return
+		}
 		if user == "" {
 			postData.Error = "Cannot Edit Post, please Sign Up or Log In"
 
@@ -1089,6 +1235,7 @@ func EditGroupPost(w http.ResponseWriter, r *http.Request) {
 			if postData.Image == "" {
 				postData.Image = currentPost.Image
 			}
+			postData.Author = currentPost.Author
 			err = UpdateGroupPost(postData)
 			if err != nil {
 				postData.Error = "Error Editing Post please try again later"
@@ -1123,6 +1270,22 @@ func CreateGroupPostComment(w http.ResponseWriter, r *http.Request) {
 		}
 		fmt.Println("check comment-id", commentData)
 		user := LoggedInUser(r).Nickname
+		if !CanInteract(user, GetGroupPost(commentData.PostId, user)) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(JsonMessage("post not found"))
+			return
+		}
+		// Replies must answer a comment on the same post.
+		if commentData.ParentId != "" && GetGroupPostComment(commentData.ParentId, user).PostId != commentData.PostId {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(JsonMessage("comment not found"))
+			return
+		}
+		if message := CommentError(commentData); message != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(JsonMessage(message))
+			return
+		}
 		commentData.CommentId = Generate()
 		commentData.Author = user
 		AddCommentErr := AddGroupPostComment(commentData)
@@ -1132,9 +1295,11 @@ func CreateGroupPostComment(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "application/json")
 			w.Write(content)
 		} else {
+			page, _ := GetComments("group-comment", commentData.PostId, user, "oldest", "", CommentPageSize)
 			allComments := ReturnGroupComments{
-				TotalComments: GetGroupPostComments(commentData.PostId, user),
-				Post:          GetGroupPost(commentData.PostId, user),
+				TotalComments:  page.Comments,
+				CommentsCursor: page.NextCursor,
+				Post:           GetGroupPost(commentData.PostId, user),
 			}
 			content, _ := json.Marshal(allComments)
 			w.Header().Set("Content-Type", "application/json")
@@ -1154,12 +1319,19 @@ func GroupPostCommentInteractions(w http.ResponseWriter, r *http.Request) {
 		panic(err)
 	}
 	user := LoggedInUser(r).Nickname
+	comment := GetGroupPostComment(likeData.CommentId, user)
+	if comment.CommentId == "" || !CanInteract(user, GetGroupPost(comment.PostId, user)) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(JsonMessage("comment not found"))
+		return
+	}
 	if likeData.Type == "delete" {
-		commentData := GetGroupPostComment(likeData.CommentId, user)
-		if user != commentData.Author {
-			commentData.Error = "you are NOT the author"
+		commentData := comment
+		if !CanDeleteComment(user, "group-comment", commentData) {
+			commentData.Error = "you cannot delete this comment"
 			content, _ := json.Marshal(commentData)
 			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
 			w.Write(content)
 		} else {
 			err = RemoveGroupPostComment(commentData.CommentId)
@@ -1173,6 +1345,10 @@ func GroupPostCommentInteractions(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func EditGroupPostComment(w http.ResponseWriter, r *http.Request) {
+	editTypedComment(w, r, "group-comment")
+}
+
 func CreateGroupEvent(w http.ResponseWriter, r *http.Request) {
 	var eventData GroupEventFields
 	body, err := ioutil.ReadAll(r.Body)
@@ -1184,6 +1360,11 @@ func CreateGroupEvent(w http.ResponseWriter, r *http.Request) {
 		panic(err)
 	}
 	user := LoggedInUser(r).Nickname
+	if !CanInteract(user, GetGroup(eventData.GroupId)) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(JsonMessage("group not found"))
+		return
+	}
 	eventData.EventId = Generate()
 	eventData.Organiser = user
 	err = AddGroupEvent(eventData)
@@ -1204,6 +1385,11 @@ func GetGroupEvents(w http.ResponseWriter, r *http.Request) {
 	}
 	groupId := string(body)
 	user := LoggedInUser(r).Nickname
+	if !CanView(user, GetGroup(groupId)) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(JsonMessage("group not found"))
+		return
+	}
 	allEvents := GetEvents(groupId, user)
 	content, _ := json.Marshal(allEvents)
 	w.Header().Set("Content-Type", "application/json")
@@ -1221,6 +1407,11 @@ func EventInteractions(w http.ResponseWriter, r *http.Request) {
 		panic(err)
 	}
 	user := LoggedInUser(r).Nickname
+	if !CanInteract(user, GetEvent(eventData.EventId, user)) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(JsonMessage("event not found"))
+		return
+	}
 	if eventData.Status == "attendance" {
 		attendanceData := GetEventAttendees(eventData.EventId)
 		var sliceOfAttendees []User
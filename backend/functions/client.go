@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"reflect"
 	"strings"
 	"sync"
 
@@ -17,6 +18,9 @@ var upgrader = websocket.Upgrader{
 }
 var wg sync.WaitGroup
 
+// Messages queued for a connection before the hub starts dropping its realtime events.
+const sendBufferSize = 256
+
 // connection is an middleman between the websocket connection and the hub.
 type connection struct {
 	// The websocket connection.
@@ -32,7 +36,7 @@ func (data *message) setFieldType(dataFromWs []byte) error {
 	errReadingChat := json.Unmarshal(dataFromWs, &chatFields)
 	if errReadingChat != nil {
 		return errReadingChat
-	} else if chatFields != (ChatFields{}) {
+	} else if !reflect.DeepEqual(chatFields, ChatFields{}) {
 		data.incomingData = chatFields
 		return nil
 	}
@@ -65,7 +69,7 @@ func (data *message) setFieldType(dataFromWs []byte) error {
 	errReadingGroupPost := json.Unmarshal(dataFromWs, &groupPostFields)
 	if errReadingGroupPost != nil {
 		return errReadingGroupPost
-	} else if groupPostFields != (GroupPostFields{}) {
+	} else if !reflect.DeepEqual(groupPostFields, GroupPostFields{}) {
 		data.incomingData = groupPostFields
 		return nil
 	}
@@ -77,6 +81,14 @@ func (data *message) setFieldType(dataFromWs []byte) error {
 		data.incomingData = resetRequest
 		return nil
 	}
+	var presenceFields presenceMessage
+	errReadingPresence := json.Unmarshal(dataFromWs, &presenceFields)
+	if errReadingPresence != nil {
+		return errReadingPresence
+	} else if presenceFields.Status != "" {
+		data.incomingData = presenceFields
+		return nil
+	}
 	//add else if conditions for other fields like notification, followers etc...
 	return nil
 }
@@ -107,6 +119,7 @@ func (s *subscription) readPump() {
 			chatData := data.incomingData.(ChatFields)
 			chatData.Id = s.room
 			chatData.MessageId = Generate()
+			chatData.Mentions = ResolveMentions(chatData.Message)
 
 			//send notifications to online users
 			chatRoom := GetChatRoom(s.room, s.name)
@@ -209,11 +222,18 @@ func (s *subscription) readPump() {
 					} else {
 						if !followerRequestExist {
 							SqlExec.followMessageData <- followData
-							s.conn.send <- message{incomingData: RequestNotifcationFields{FollowRequest: followData}}
+							// Muted senders still have their request stored, but it is not pushed.
+							if !IsMutedContent(user.Nickname, sender.Nickname, "", "") {
+								s.conn.send <- message{incomingData: RequestNotifcationFields{FollowRequest: followData}}
+							}
 						}
 					}
 				}
 			} else {
+				if followData.IsFollowing && followData.FollowRequestAccepted {
+					// Accepted over the websocket, close the pending request.
+					UpdateFollowRequestState(GetPendingFollowRequest(sender.Nickname, user.Nickname).Id, "accepted")
+				}
 				H.broadcast <- data
 			}
 		case GroupFields:
@@ -225,6 +245,12 @@ func (s *subscription) readPump() {
 			//delete request notifications.
 			requestNotifcationFields := data.incomingData.(RequestNotifcationFields)
 			SqlExec.RequestNotificationData <- requestNotifcationFields
+		case presenceMessage:
+			//tab reports whether the user is idle or active again.
+			presenceData := data.incomingData.(presenceMessage)
+			if s.room == "" && s.groupRoom == "" {
+				H.presence <- presenceChange{sub: s, idle: presenceData.Status == "idle"}
+			}
 		}
 	}
 }
@@ -287,6 +313,26 @@ func (s *subscription) writePump() {
 			if err := c.ws.WriteJSON(request); err != nil {
 				log.Printf("error sending update message: %v", err)
 			}
+		case presenceMessage:
+			presence := message.incomingData.(presenceMessage)
+			if err := c.ws.WriteJSON(presence); err != nil {
+				log.Printf("error sending presence message: %v", err)
+			}
+		case MentionNotification:
+			mention := message.incomingData.(MentionNotification)
+			if err := c.ws.WriteJSON(mention); err != nil {
+				log.Printf("error sending mention notification: %v", err)
+			}
+		case ReplyNotification:
+			reply := message.incomingData.(ReplyNotification)
+			if err := c.ws.WriteJSON(reply); err != nil {
+				log.Printf("error sending reply notification: %v", err)
+			}
+		case PollFields:
+			poll := message.incomingData.(PollFields)
+			if err := c.ws.WriteJSON(poll); err != nil {
+				log.Printf("error sending poll results: %v", err)
+			}
 		}
 
 	}
@@ -318,8 +364,8 @@ func ServeWs(w http.ResponseWriter, r *http.Request) {
 		user = LoggedInUser(r).Nickname
 		groupId = ""
 	}
-	c := &connection{send: make(chan message), ws: ws}
-	s := subscription{c, id, groupId, user, cookie.Value}
+	c := &connection{send: make(chan message, sendBufferSize), ws: ws}
+	s := subscription{c, id, groupId, user, cookie.Value, false}
 
 	H.register <- &s
 	go s.writePump()
@@ -2,17 +2,24 @@ package functions
 
 import (
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"math/rand"
 	"net/http"
+	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
+	"unicode"
+	"unicode/utf16"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 // Get user from forms.
@@ -99,6 +106,7 @@ func CheckErr(err error, line string) {
 func AddGroup(groupFields GroupFields, creator string) error {
 	groupFields.Users = creator
 	groupFields.Admin = creator
+	groupFields.Avatar = StoreDataUrl(groupFields.Avatar, creator)
 	db := OpenDB()
 	defer db.Close()
 	stmt, err := db.Prepare(`INSERT INTO "groups" (id,name,description,users,admin,avatar) values (?, ?, ?, ?, ?, ?)`)
@@ -343,6 +351,8 @@ func UpdateGroup(groupRoom GroupFields, action string, user string) GroupFields
 //
 
 func AddGroupPost(postFields GroupPostFields) error {
+	postFields.Image = StoreDataUrl(postFields.Image, postFields.Author)
+	postFields.Thread = NormaliseThread(postFields.Thread)
 	db := OpenDB()
 	defer db.Close()
 	stmt, err := db.Prepare(`INSERT into "groupposts" (id, postid , author, image, text, thread, time) VALUES (?, ?, ?, ?, ?, ?, ?)`)
@@ -351,10 +361,42 @@ func AddGroupPost(postFields GroupPostFields) error {
 		return err
 	}
 	stmt.Exec(postFields.Id, postFields.PostId, postFields.Author, postFields.Image, postFields.Text, postFields.Thread, postFields.Time)
+	SaveTags("group-post", postFields.PostId, postFields.Thread, postFields.Time)
+	SaveMentions("group-post", postFields.PostId, postFields.Id, postFields.Author, postFields.Text, postFields.Time, func(user string) bool {
+		return CanView(user, postFields)
+	})
+	QueueLinkPreview("group-post", postFields.PostId, postFields.Text)
+	if postFields.Poll != nil {
+		return AddPoll("group-post", postFields.PostId, postFields.Id, *postFields.Poll)
+	}
 	return nil
 }
 
+// Push a new group post to the other members of the group who are online.
+func BroadcastGroupPost(postFields GroupPostFields) {
+	group := GetGroup(postFields.Id)
+	postFields.Group = group
+	postFields.Mentions = ResolveMentions(postFields.Text)
+	postFields.Poll = GetItemPoll("group-post", postFields.PostId, "")
+	potentialMember := strings.Split(group.Users, ",")
+	for _, member := range potentialMember {
+		if member != postFields.Author && !IsMutedContent(member, postFields.Author, postFields.Text, postFields.Thread) {
+			loggedInMember := H.user[member]
+			for userSub := range loggedInMember {
+				userSub.conn.send <- message{incomingData: postFields}
+			}
+		}
+	}
+}
+
 func UpdateGroupPost(postFields GroupPostFields) error {
+	postFields.Image = StoreDataUrl(postFields.Image, postFields.Author)
+	postFields.Thread = NormaliseThread(postFields.Thread)
+	current := GetGroupPost(postFields.PostId, postFields.Author)
+	if err := SaveRevision(RevisionFields{Type: "group-post", Id: current.PostId, Author: current.Author, Image: current.Image, Text: current.Text, Thread: current.Thread, Time: current.Time},
+		RevisionFields{Image: postFields.Image, Text: postFields.Text, Thread: postFields.Thread}); err != nil {
+		return err
+	}
 	db := OpenDB()
 	defer db.Close()
 	stmt, err := db.Prepare(`UPDATE "groupposts" SET "text" = ?, "thread" = ?, "image" = ? WHERE "postid" = ?`)
@@ -362,6 +404,11 @@ func UpdateGroupPost(postFields GroupPostFields) error {
 		fmt.Println("Cannot update post")
 	}
 	stmt.Exec(postFields.Text, postFields.Thread, postFields.Image, postFields.PostId)
+	SaveTags("group-post", postFields.PostId, postFields.Thread, 0)
+	SaveMentions("group-post", current.PostId, current.Id, current.Author, postFields.Text, 0, func(user string) bool {
+		return CanView(user, current)
+	})
+	QueueLinkPreview("group-post", current.PostId, postFields.Text)
 	return err
 }
 
@@ -373,14 +420,22 @@ func RemoveGroupPost(id string) error {
 		fmt.Println("error removing post from posts table", err)
 	}
 	stmt.Exec(id)
+	RemoveTags("group-post", id)
+	RemoveMentions("group-post", id)
+	RemoveRevisions("group-post", id)
+	RemoveReactions("group-post", id)
+	RemovePoll("group-post", id)
+	RemoveLinkPreview("group-post", id)
+	RemoveItemBookmarks("group-post", id)
 	return err
 }
 func GetGroupPosts(user, groupId string) []GroupPostFields {
 	db := OpenDB()
 	defer db.Close()
 	sliceOfPostTableRows := []GroupPostFields{}
-	s := fmt.Sprintf("SELECT * FROM groupposts WHERE id = '%v'", groupId)
-	rows, err := db.Query(s)
+	// Muted authors, words and hashtags are filtered out in the query.
+	s := "SELECT * FROM groupposts WHERE id = ? AND " + MutedContentFilter("groupposts")
+	rows, err := db.Query(s, groupId, user, time.Now().UnixMilli())
 	if err != nil {
 		fmt.Println("error retrieving group posts", err)
 	}
@@ -402,28 +457,27 @@ func GetGroupPosts(user, groupId string) []GroupPostFields {
 			Text:         text,
 			Thread:       thread,
 			Time:         time,
-			PostComments: len(GetGroupPostComments(postId, user)),
+			PostComments: CommentCount("group-comment", postId),
 		}
 
 		row, err := PreparedQuery("SELECT * FROM users WHERE nickname = ?", postTableRows.Author, db, "GetUserFromPosts")
 		postTableRows.AuthorImg = QueryUser(row, err).Avatar
+		postTableRows.Mentions = ResolveMentions(postTableRows.Text)
+		postTableRows.EditedAt = GetLastEdit("group-post", postTableRows.PostId)
+		postTableRows.Edited = postTableRows.EditedAt != 0
 		if postTableRows.Author == user {
 			postTableRows.PostAuthor = true
 		}
-		postTableRows.PostComments = len(GetGroupPostComments(postTableRows.PostId, user))
-		postTableRows.Likes = len(GetGroupPostLikes(postTableRows.PostId, "l"))
-		postTableRows.Dislikes = len(GetGroupPostLikes(postTableRows.PostId, "d"))
-		postLike := GetGroupLike(postTableRows.PostId, user)
-		if postLike != (GroupsAndLikesFields{}) {
-			if postLike.Like == "l" {
-				postTableRows.PostLiked = true
-			} else if postLike.Like == "d" {
-				postTableRows.PostDisliked = true
-			}
-		}
+		postTableRows.Poll = GetItemPoll("group-post", postTableRows.PostId, user)
+		postTableRows.Preview = GetLinkPreview("group-post", postTableRows.PostId)
 		sliceOfPostTableRows = append(sliceOfPostTableRows, postTableRows)
 	}
 	rows.Close()
+	var posts []*GroupPostFields
+	for i := range sliceOfPostTableRows {
+		posts = append(posts, &sliceOfPostTableRows[i])
+	}
+	LoadGroupPostListReactions(posts, user)
 	return sliceOfPostTableRows
 }
 
@@ -453,160 +507,96 @@ func GetGroupPost(postId string, user string) GroupPostFields {
 			Time:       time,
 			PostAuthor: false,
 		}
-		postLike := GetGroupLike(post.PostId, user)
-		if postLike.Like == "l" {
-			post.PostLiked = true
-		} else if postLike.Like == "d" {
-			post.PostDisliked = true
-		}
-		fmt.Println()
-		post.Likes = len(GetGroupPostLikes(post.PostId, "l"))
-		post.Dislikes = len(GetGroupPostLikes(post.PostId, "d"))
-		post.PostComments = len(GetGroupPostComments(post.PostId, user))
+		LoadGroupPostReactions(&post, user)
+		post.Poll = GetItemPoll("group-post", post.PostId, user)
+		post.Preview = GetLinkPreview("group-post", post.PostId)
+		post.PostComments = CommentCount("group-comment", post.PostId)
 		row, err := PreparedQuery("SELECT * FROM users WHERE nickname = ?", post.Author, db, "GetUserFromPosts")
 		post.AuthorImg = QueryUser(row, err).Avatar
+		post.Mentions = ResolveMentions(post.Text)
+		post.EditedAt = GetLastEdit("group-post", post.PostId)
+		post.Edited = post.EditedAt != 0
 		if post.Author == user {
 			post.PostAuthor = true
 		}
 	}
 	rows.Close()
+	// Group posts are only returned to current members of the group.
+	if !CanView(user, post) {
+		return GroupPostFields{}
+	}
 	return post
 }
 
 //
-// Group Post Likes
+// Group Post Comments
 //
 
-func AddGroupLike(GroupLikes GroupsAndLikesFields) error {
-	LikedGroup := GetGroupLike(GroupLikes.PostId, GroupLikes.Username)
+func AddGroupPostComment(commentFields CommentFields) error {
+	commentFields.Image = StoreDataUrl(commentFields.Image, commentFields.Author)
+	commentFields.Thread = NormaliseThread(commentFields.Thread)
 	db := OpenDB()
 	defer db.Close()
-	var s string
-	if LikedGroup.Like == "" {
-		s = "INSERT INTO likesgroup (like, id, username) values (?, ?, ?)"
-	} else if GroupLikes.Like != LikedGroup.Like {
-		s = "UPDATE likesgroup SET like = ? WHERE id = ? AND username = ?"
-	} else {
-		s = "DELETE FROM likesgroup WHERE like = ? AND id = ? AND username = ?"
+	// A reply is stored with its place in the thread or not at all.
+	tx, err := db.Begin()
+	if err != nil {
+		fmt.Println("error adding comment", err)
+		return err
 	}
-	stmt, _ := db.Prepare(s)
-	_, err := stmt.Exec(GroupLikes.Like, GroupLikes.PostId, GroupLikes.Username)
+	_, err = tx.Exec(`INSERT INTO "groupComments" (id, postid, author, image, text, thread, time) values(?, ?, ?, ?, ?, ?, ?)`,
+		commentFields.CommentId, commentFields.PostId, commentFields.Author, commentFields.Image, commentFields.Text, commentFields.Thread, commentFields.Time)
 	if err != nil {
-		fmt.Println(err)
+		fmt.Println("Error adding comment to groupComments table", err)
+		tx.Rollback()
+		return err
 	}
-	return err
-}
-
-func GetGroupLike(id, user string) GroupsAndLikesFields {
-	GroupLikeRow := GroupsAndLikesFields{}
-	db := OpenDB()
-	defer db.Close()
-	s := fmt.Sprintf("SELECT * FROM likesgroup WHERE id = '%v' AND username = '%v'", id, user)
-	rows, _ := db.Query(s)
-	var postId string
-	var author string
-	var like string
-	if rows.Next() {
-		err := rows.Scan(&postId, &author, &like)
+	var parentAuthor string
+	if commentFields.ParentId != "" {
+		parentAuthor, err = SaveReply(tx, "group-comment", commentFields.CommentId, commentFields.ParentId)
 		if err != nil {
-			fmt.Println("error getting group post like", err)
-		}
-		GroupLikeRow = GroupsAndLikesFields{
-			PostId:   postId,
-			Username: author,
-			Like:     like,
+			tx.Rollback()
+			return err
 		}
 	}
-	rows.Close()
-	return GroupLikeRow
-}
-
-func GetGroupPostLikes(id, l string) []GroupsAndLikesFields {
-	sliceOfGroupLikesRow := []GroupsAndLikesFields{}
-	db := OpenDB()
-	defer db.Close()
-	var s string
-	if l == "all" {
-		s = fmt.Sprintf("SELECT * FROM likesgroup WHERE username = '%v' AND like = '%v'", id, "l")
-
-	} else {
-		s = fmt.Sprintf("SELECT * FROM likesgroup WHERE id = '%v' AND like = '%v'", id, l)
-
+	if err = tx.Commit(); err != nil {
+		fmt.Println("error adding comment", err)
+		return err
 	}
-
-	rows, _ := db.Query(s)
-	var postId string
-	var author string
-	var like string
-	if rows.Next() {
-		err := rows.Scan(&postId, &author, &like)
-		if err != nil {
-			fmt.Println("error getting group post likes", err)
-		}
-		likedRows := GroupsAndLikesFields{
-			PostId:   postId,
-			Username: author,
-			Like:     like,
-		}
-		sliceOfGroupLikesRow = append(sliceOfGroupLikesRow, likedRows)
+	fmt.Println("added comment to groupComments table")
+	SaveTags("group-comment", commentFields.CommentId, commentFields.Thread, commentFields.Time)
+	post := GetGroupPost(commentFields.PostId, commentFields.Author)
+	SaveMentions("group-comment", commentFields.CommentId, commentFields.PostId, commentFields.Author, commentFields.Text, commentFields.Time, func(user string) bool {
+		return CanView(user, post)
+	})
+	if commentFields.ParentId != "" {
+		NotifyReply("group-comment", commentFields, post.Author, parentAuthor, func(user string) bool {
+			return CanView(user, post)
+		})
 	}
-	rows.Close()
-	return sliceOfGroupLikesRow
+	return nil
 }
 
-//
-// Group Post Comments
-//
-
-func AddGroupPostComment(commentFields CommentFields) error {
-	db := OpenDB()
-	stmt, err := db.Prepare(`INSERT INTO "groupComments" (id, postid, author, image, text, thread, time) values(?, ?, ?, ?, ?, ?, ?)`)
-	if err != nil {
-		log.Fatal("error preparing to comment table:= ", err)
+func UpdateGroupPostComment(commentFields CommentFields) error {
+	commentFields.Image = StoreDataUrl(commentFields.Image, commentFields.Author)
+	commentFields.Thread = NormaliseThread(commentFields.Thread)
+	current := GetGroupPostComment(commentFields.CommentId, commentFields.Author)
+	if err := SaveRevision(RevisionFields{Type: "group-comment", Id: current.CommentId, Author: current.Author, Image: current.Image, Text: current.Text, Thread: current.Thread, Time: current.Time},
+		RevisionFields{Image: commentFields.Image, Text: commentFields.Text, Thread: commentFields.Thread}); err != nil {
 		return err
 	}
-	_, err = stmt.Exec(commentFields.CommentId, commentFields.PostId, commentFields.Author, commentFields.Image, commentFields.Text, commentFields.Thread, commentFields.Time)
+	db := OpenDB()
+	defer db.Close()
+	_, err := db.Exec(`UPDATE "groupComments" SET "text" = ?, "thread" = ?, "image" = ? WHERE "id" = ?`, commentFields.Text, commentFields.Thread, commentFields.Image, commentFields.CommentId)
 	if err != nil {
-		log.Fatal("Error adding comment to groupComment table", err)
+		fmt.Println("Cannot update comment", err)
 		return err
 	}
-	fmt.Println("added comment to groupComment table")
-	return err
-}
-
-func GetGroupPostComments(postId, user string) []CommentFields {
-	// fmt.Println(user, "postId", postId)
-	db := OpenDB()
-	s := fmt.Sprintf("SELECT * FROM groupComments WHERE postid = '%v'", postId)
-
-	sliceOfCommentRows := []CommentFields{}
-	rows, _ := db.Query(s)
-	var commentid, postid, author, image, thread, text string
-	var time int
-
-	for rows.Next() {
-		rows.Scan(&commentid, &postid, &author, &image, &text, &thread, &time)
-		commentRows := CommentFields{
-			CommentId:     commentid,
-			PostId:        postid,
-			Author:        author,
-			Image:         image,
-			Text:          text,
-			Thread:        thread,
-			Time:          time,
-			CommentAuthor: false,
-		}
-
-		row, err := PreparedQuery("SELECT * FROM users WHERE nickname = ?", commentRows.Author, db, "GetUserFromPosts")
-		commentRows.AuthorImg = QueryUser(row, err).Avatar
-
-		if commentRows.Author == user {
-			commentRows.CommentAuthor = true
-		}
-		sliceOfCommentRows = append(sliceOfCommentRows, commentRows)
-	}
-	rows.Close()
-	return sliceOfCommentRows
+	SaveTags("group-comment", current.CommentId, commentFields.Thread, 0)
+	post := GetGroupPost(current.PostId, current.Author)
+	SaveMentions("group-comment", current.CommentId, current.PostId, current.Author, commentFields.Text, 0, func(user string) bool {
+		return CanView(user, post)
+	})
+	return nil
 }
 
 func RemoveGroupPostComment(id string) error {
@@ -616,10 +606,24 @@ func RemoveGroupPostComment(id string) error {
 		fmt.Println("error removing comment from groupComment table", err)
 	}
 	stmt.Exec(id)
+	RemoveTags("group-comment", id)
+	RemoveMentions("group-comment", id)
+	RemoveRevisions("group-comment", id)
+	RemoveReactions("group-comment", id)
+	RemoveReplies("group-comment", id)
 	return err
 }
 
 func GetGroupPostComment(commentId, user string) CommentFields {
+	comment := getGroupPostComment(commentId, user)
+	if comment.CommentId != "" {
+		LoadCommentReactions(&comment, "group-comment", user)
+	}
+	return comment
+}
+
+// Like GetGroupPostComment, without reactions for listings that load them for the whole page.
+func getGroupPostComment(commentId, user string) CommentFields {
 	db := OpenDB()
 	s := fmt.Sprintf("SELECT * FROM groupComments WHERE id = '%v'", commentId)
 	rows, _ := db.Query(s)
@@ -640,9 +644,15 @@ func GetGroupPostComment(commentId, user string) CommentFields {
 		}
 		row, err := PreparedQuery("SELECT * FROM users WHERE nickname = ?", commentPost.Author, db, "GetUserFromPosts")
 		commentPost.AuthorImg = QueryUser(row, err).Avatar
+		commentPost.Mentions = ResolveMentions(commentPost.Text)
+		commentPost.EditedAt = GetLastEdit("group-comment", commentPost.CommentId)
+		commentPost.Edited = commentPost.EditedAt != 0
 		if commentPost.Author == user {
 			commentPost.CommentAuthor = true
 		}
+		LoadReplyFields(&commentPost, "group-comment")
+		commentPost.CanEdit = CanEditComment(user, commentPost)
+		commentPost.CanDelete = CanDeleteComment(user, "group-comment", commentPost)
 	}
 	rows.Close()
 	return commentPost
@@ -700,6 +710,7 @@ func AddChat(chatFields ChatRoomFields, creator string) error {
 	sort.Strings(sliceOfUsers)
 	chatFields.Users = strings.Join(sliceOfUsers, ",")
 	chatFields.Admin = creator
+	chatFields.Avatar = StoreDataUrl(chatFields.Avatar, creator)
 	db := OpenDB()
 	defer db.Close()
 	stmt, err := db.Prepare(`INSERT INTO "chatroom" (id, name,description,type,users,admin,avatar) values (?, ?, ?, ?, ?, ?, ?)`)
@@ -859,6 +870,11 @@ func AddMessage(chatFields ChatFields) error {
 		fmt.Println("error adding to table:", errorWithTable)
 		return errorWithTable
 	}
+	members := strings.Split(GetChatRoom(chatFields.Id, chatFields.Sender).Users, ",")
+	SaveMentions("chat", chatFields.MessageId, chatFields.Id, chatFields.Sender, chatFields.Message, chatFields.Date, func(user string) bool {
+		return Contains(members, user)
+	})
+	QueueLinkPreview("chat", chatFields.MessageId, chatFields.Message)
 	return nil
 }
 func GetPreviousMessages(chatroomId string) []ChatFields {
@@ -881,6 +897,8 @@ func GetPreviousMessages(chatroomId string) []ChatFields {
 			MessageId: messageId,
 			Message:   message,
 			Date:      date,
+			Mentions:  ResolveMentions(message),
+			Preview:   GetLinkPreview("chat", messageId),
 		}
 		messages = append(messages, m)
 	}
@@ -892,17 +910,44 @@ func GetPreviousMessages(chatroomId string) []ChatFields {
 // Posts
 //
 
-func AddPost(postFields PostFields) {
+func AddPost(postFields PostFields) error {
+	postFields.Image = StoreDataUrl(postFields.Image, postFields.Author)
+	postFields.Thread = NormaliseThread(postFields.Thread)
 	db := OpenDB()
 	defer db.Close()
 	stmt, err := db.Prepare(`INSERT into "posts"(id,author,image,text,thread,time,privacy,viewers) VALUES (?,?,?,?,?,?,?,?)`)
 	if err != nil {
 		fmt.Println("error add post to table", err)
+		return err
+	}
+	_, err = stmt.Exec(postFields.Id, postFields.Author, postFields.Image, postFields.Text, postFields.Thread, postFields.Time, postFields.Privacy, postFields.Viewers)
+	if err != nil {
+		fmt.Println("error add post to table", err)
+		return err
+	}
+	if postFields.Poll != nil {
+		// A post is not left behind without the poll it was written with.
+		if err := AddPoll("post", postFields.Id, "", *postFields.Poll); err != nil {
+			db.Exec(`DELETE FROM "posts" WHERE id = ?`, postFields.Id)
+			return err
+		}
 	}
-	stmt.Exec(postFields.Id, postFields.Author, postFields.Image, postFields.Text, postFields.Thread, postFields.Time, postFields.Privacy, postFields.Viewers)
+	SaveTags("post", postFields.Id, postFields.Thread, postFields.Time)
+	SaveMentions("post", postFields.Id, postFields.Id, postFields.Author, postFields.Text, postFields.Time, func(user string) bool {
+		return CanView(user, postFields)
+	})
+	QueueLinkPreview("post", postFields.Id, postFields.Text)
+	return nil
 }
 
 func UpdatePost(postFields PostFields) error {
+	postFields.Image = StoreDataUrl(postFields.Image, postFields.Author)
+	postFields.Thread = NormaliseThread(postFields.Thread)
+	current := GetPost(postFields.Id, postFields.Author)
+	if err := SaveRevision(RevisionFields{Type: "post", Id: current.Id, Author: current.Author, Image: current.Image, Text: current.Text, Thread: current.Thread, Time: current.Time},
+		RevisionFields{Image: postFields.Image, Text: postFields.Text, Thread: postFields.Thread}); err != nil {
+		return err
+	}
 	db := OpenDB()
 	defer db.Close()
 	stmt, err := db.Prepare(`UPDATE "posts" SET "text" = ?, "thread" = ?, "image" = ? WHERE "id" = ?`)
@@ -910,6 +955,11 @@ func UpdatePost(postFields PostFields) error {
 		fmt.Println("Cannot update post")
 	}
 	stmt.Exec(postFields.Text, postFields.Thread, postFields.Image, postFields.Id)
+	SaveTags("post", postFields.Id, postFields.Thread, 0)
+	SaveMentions("post", current.Id, current.Id, current.Author, postFields.Text, 0, func(user string) bool {
+		return CanView(user, current)
+	})
+	QueueLinkPreview("post", current.Id, postFields.Text)
 	return err
 }
 
@@ -921,13 +971,22 @@ func RemovePost(id string) error {
 		fmt.Println("error removing post from posts table", err)
 	}
 	stmt.Exec(id)
+	RemoveRepost(id)
+	RemoveTags("post", id)
+	RemoveMentions("post", id)
+	RemoveRevisions("post", id)
+	RemoveReactions("post", id)
+	RemovePoll("post", id)
+	RemoveLinkPreview("post", id)
+	RemoveItemBookmarks("post", id)
 	return err
 }
 func GetUserPosts(user, privateness string) []PostFields {
 	db := OpenDB()
 	defer db.Close()
 	sliceOfPostTableRows := []PostFields{}
-	rows, _ := db.Query(`SELECT * FROM "posts"`)
+	// Muted authors, words and hashtags are filtered out in the query.
+	rows, _ := db.Query(`SELECT * FROM "posts" WHERE `+MutedContentFilter("posts"), user, time.Now().UnixMilli())
 	var id string
 	var author string
 	var image string
@@ -949,47 +1008,36 @@ func GetUserPosts(user, privateness string) []PostFields {
 			Privacy:      privacy,
 			Viewers:      viewers,
 			PostAuthor:   false,
-			PostComments: len(GetPostComments(id, user)),
-			Likes:        len(GetPostLikes(id, "l")),
-			Dislikes:     len(GetPostLikes(id, "d")),
+			PostComments: CommentCount("comment", id),
 		}
 		row, err := PreparedQuery("SELECT * FROM users WHERE nickname = ?", postTableRows.Author, db, "GetUserFromPosts")
 		postTableRows.AuthorImg = QueryUser(row, err).Avatar
+		postTableRows.Mentions = ResolveMentions(postTableRows.Text)
+		LoadRepost(&postTableRows, user)
+		postTableRows.EditedAt = GetLastEdit("post", postTableRows.Id)
+		postTableRows.Edited = postTableRows.EditedAt != 0
 		if postTableRows.Author == user {
 			postTableRows.PostAuthor = true
 		}
-		postTableRows.Likes = len(GetPostLikes(postTableRows.Id, "l"))
-		postLike := GetPostLike(postTableRows.Id, user)
-		if postLike.Like == "l" {
-			postTableRows.PostLiked = true
-		} else if postLike.Like == "d" {
-			postTableRows.PostDisliked = true
-		}
-		postTableRows.Dislikes = len(GetPostLikes(postTableRows.Id, "d"))
-		postTableRows.PostComments = len(GetPostComments(postTableRows.Id, user))
+		postTableRows.Poll = GetItemPoll("post", postTableRows.Id, user)
+		postTableRows.Preview = GetLinkPreview("post", postTableRows.Id)
 		if privateness == "public" {
 			if postTableRows.Privacy == "public" {
 				sliceOfPostTableRows = append(sliceOfPostTableRows, postTableRows)
 			}
 		} else {
-			if postTableRows.Privacy == "private" {
-				row, err := PreparedQuery("SELECT * FROM users WHERE nickname = ?", user, db, "GetUserFromPosts")
-				friends := GetFollowing(QueryUser(row, err))
-				friends = append(friends, user)
-				if Contains(friends, postTableRows.Author) {
-					sliceOfPostTableRows = append(sliceOfPostTableRows, postTableRows)
-				}
-			} else if postTableRows.Privacy == "almost-private" {
-				viewPost := strings.Split(postTableRows.Viewers, ",")
-				viewPost = append(viewPost, postTableRows.Author)
-				if Contains(viewPost, user) {
-					sliceOfPostTableRows = append(sliceOfPostTableRows, postTableRows)
-				}
+			if postTableRows.Privacy != "public" && CanView(user, postTableRows) {
+				sliceOfPostTableRows = append(sliceOfPostTableRows, postTableRows)
 			}
 
 		}
 	}
 	rows.Close()
+	var posts []*PostFields
+	for i := range sliceOfPostTableRows {
+		posts = append(posts, &sliceOfPostTableRows[i])
+	}
+	LoadPostListReactions(posts, user)
 	return sliceOfPostTableRows
 }
 
@@ -1019,423 +1067,3407 @@ func GetPost(postId string, user string) PostFields {
 			Thread:       thread,
 			Time:         time,
 			PostAuthor:   false,
-			PostComments: len(GetPostComments(postId, user)),
-			Likes:        len(GetPostLikes(postId, "l")),
-			Dislikes:     len(GetPostLikes(postId, "d")),
+			PostComments: CommentCount("comment", postId),
 			Privacy:      privacy,
 			Viewers:      viewers,
 		}
 		row, err := PreparedQuery("SELECT * FROM users WHERE nickname = ?", post.Author, db, "GetUserFromPosts")
 		post.AuthorImg = QueryUser(row, err).Avatar
+		post.Mentions = ResolveMentions(post.Text)
+		LoadRepost(&post, user)
+		post.EditedAt = GetLastEdit("post", post.Id)
+		post.Edited = post.EditedAt != 0
 		if post.Author == user {
 			post.PostAuthor = true
 		}
-		postLike := GetPostLike(post.Id, user)
-		if postLike.Like == "l" {
-			post.PostLiked = true
-		} else if postLike.Like == "d" {
-			post.PostDisliked = true
-		}
+		LoadPostReactions(&post, user)
+		post.Poll = GetItemPoll("post", post.Id, user)
+		post.Preview = GetLinkPreview("post", post.Id)
 	}
 	rows.Close()
+	// Posts the user may not see are returned empty, as if they did not exist.
+	if !CanView(user, post) {
+		return PostFields{}
+	}
 	return post
 }
 
 //
-// Likes
+// Feed
 //
 
-func GetPostLike(id, user string) LikesFields {
-	sliceOfLikeRows := LikesFields{}
+// Sources that make up the home feed.
+var FeedSources = []string{"public", "followers", "almost-private", "audience", "groups"}
+
+// Get a page of the home feed, newest first. Every source is a filtered SQL query using
+// the time indexes, merged with UNION ALL so only the page being returned is read.
+// When tag is set only posts and group posts carrying that hashtag are returned.
+func GetFeed(viewer User, sources []string, tag, cursor string, limit int) (FeedPage, error) {
+	page := FeedPage{Items: []FeedItem{}}
+	beforeTime, beforeId := int64(math.MaxInt64), ""
+	if key, err := DecodeCursor(cursor); err != nil {
+		return page, err
+	} else if len(key) == 2 {
+		if beforeTime, err = strconv.ParseInt(key[0], 10, 64); err != nil {
+			return page, err
+		}
+		beforeId = key[1]
+	}
+	now := time.Now().UnixMilli()
+	tagFilter := func(targetType, idColumn string) string {
+		if tag == "" {
+			return ""
+		}
+		return " AND EXISTS (SELECT 1 FROM tagLinks l JOIN tags t ON t.id = l.tagId WHERE t.name = ? AND l.targetType = '" + targetType + "' AND l.targetId = " + idColumn + ")"
+	}
+
+	var postConditions []string
+	var postArgs []interface{}
+	if Contains(sources, "public") {
+		postConditions = append(postConditions, "p.privacy = 'public'")
+	}
+	if Contains(sources, "followers") {
+		postConditions = append(postConditions, `(p.privacy = 'private' AND (p.author = ? OR p.author IN
+			(SELECT u.nickname FROM followers f JOIN users u ON u.email = f.followee WHERE f.follower = ?)))`)
+		postArgs = append(postArgs, viewer.Nickname, viewer.Email)
+	}
+	if Contains(sources, "almost-private") {
+		postConditions = append(postConditions, "(p.privacy = 'almost-private' AND (p.author = ? OR instr(',' || p.viewers || ',', ',' || ? || ',') > 0))")
+		postArgs = append(postArgs, viewer.Nickname, viewer.Nickname)
+	}
+	if Contains(sources, "audience") {
+		postConditions = append(postConditions, "(p.privacy = 'audience' AND (p.author = ? OR p.viewers IN (SELECT audienceId FROM audienceMembers WHERE member = ?)))")
+		postArgs = append(postArgs, viewer.Nickname, viewer.Nickname)
+	}
+
+	// Reposts and quotes are only shown when the viewer can see the original, whatever the source.
+	originalVisible, originalArgs := visiblePostSQL("o", viewer)
+
+	var branches []string
+	var args []interface{}
+	if len(postConditions) > 0 {
+		branches = append(branches, `SELECT 'post' AS kind, p.id AS id, '' AS groupId, '' AS groupName, '' AS groupAvatar, p.author, IFNULL(u.avatar, ''),
+			IFNULL(p.image, ''), IFNULL(p.text, ''), IFNULL(p.thread, ''), p.time AS time, p.privacy, IFNULL(p.viewers, ''),
+			(SELECT COUNT(*) FROM comments c WHERE c.postid = p.id),
+			IFNULL((SELECT MAX(r.time) FROM revisions r WHERE r.targetType = 'post' AND r.targetId = p.id), 0)
+			FROM posts p LEFT JOIN users u ON u.nickname = p.author
+			WHERE (`+strings.Join(postConditions, " OR ")+`)
+			AND NOT EXISTS (SELECT 1 FROM reposts rp WHERE rp.postId = p.id AND rp.originalDeleted = 0
+				AND NOT EXISTS (SELECT 1 FROM posts o WHERE o.id = rp.originalId AND `+originalVisible+`))
+			AND (p.time < ? OR (p.time = ? AND p.id < ?)) AND `+MutedContentFilter("p")+tagFilter("post", "p.id"))
+		args = append(args, postArgs...)
+		args = append(args, originalArgs...)
+		args = append(args, beforeTime, beforeTime, beforeId, viewer.Nickname, now)
+		if tag != "" {
+			args = append(args, tag)
+		}
+	}
+	if Contains(sources, "groups") {
+		branches = append(branches, `SELECT 'group-post', p.postid, p.id, IFNULL(g.name, ''), IFNULL(g.avatar, ''), p.author, IFNULL(u.avatar, ''),
+			IFNULL(p.image, ''), IFNULL(p.text, ''), IFNULL(p.thread, ''), p.time, '', '',
+			(SELECT COUNT(*) FROM groupComments c WHERE c.postid = p.postid),
+			IFNULL((SELECT MAX(r.time) FROM revisions r WHERE r.targetType = 'group-post' AND r.targetId = p.postid), 0)
+			FROM groupposts p JOIN groups g ON g.id = p.id LEFT JOIN users u ON u.nickname = p.author
+			WHERE (instr(',' || g.users || ',', ',' || ? || ',') > 0 OR g.admin = ?)
+			AND (p.time < ? OR (p.time = ? AND p.postid < ?)) AND `+MutedContentFilter("p")+tagFilter("group-post", "p.postid"))
+		args = append(args, viewer.Nickname, viewer.Nickname, beforeTime, beforeTime, beforeId, viewer.Nickname, now)
+		if tag != "" {
+			args = append(args, tag)
+		}
+	}
+	if len(branches) == 0 {
+		return page, nil
+	}
+	args = append(args, limit+1)
+
 	db := OpenDB()
 	defer db.Close()
-	s := fmt.Sprintf("SELECT * FROM likes WHERE id = '%v' AND username = '%v'", id, user)
-	rows, _ := db.Query(s)
-	var postid string
-	var author string
-	var like string
-	if rows.Next() {
-		rows.Scan(&postid, &author, &like)
-		sliceOfLikeRows = LikesFields{
-			PostId:   postid,
-			Username: author,
-			Like:     like,
+	rows, err := db.Query(strings.Join(branches, " UNION ALL ")+" ORDER BY time DESC, id DESC LIMIT ?", args...)
+	if err != nil {
+		fmt.Println("error getting feed", err)
+		return page, err
+	}
+	var lastTime int
+	var lastId string
+	scanned := 0
+	for rows.Next() {
+		scanned++
+		var kind, id, groupId, groupName, groupAvatar, author, authorImg, image, text, thread, privacy, viewers string
+		var postTime, comments, editedAt int
+		err := rows.Scan(&kind, &id, &groupId, &groupName, &groupAvatar, &author, &authorImg, &image, &text, &thread,
+			&postTime, &privacy, &viewers, &comments, &editedAt)
+		if err != nil {
+			fmt.Println("error reading feed", err)
+			continue
+		}
+		if len(page.Items) == limit {
+			page.NextCursor = EncodeCursor(strconv.Itoa(lastTime), lastId)
+			break
 		}
+		if kind == "post" {
+			post := PostFields{
+				Id:           id,
+				Author:       author,
+				AuthorImg:    authorImg,
+				Image:        image,
+				Text:         text,
+				Thread:       thread,
+				Time:         postTime,
+				Privacy:      privacy,
+				Viewers:      viewers,
+				PostComments: comments,
+				PostAuthor:   author == viewer.Nickname,
+				Mentions:     ResolveMentions(text),
+				Edited:       editedAt != 0,
+				EditedAt:     editedAt,
+			}
+			LoadRepost(&post, viewer.Nickname)
+			if !CanView(viewer.Nickname, post) {
+				lastTime, lastId = postTime, id
+				continue
+			}
+			post.Poll = GetItemPoll("post", post.Id, viewer.Nickname)
+			post.Preview = GetLinkPreview("post", post.Id)
+			page.Items = append(page.Items, FeedItem{Kind: kind, Post: &post})
+		} else {
+			groupPost := GroupPostFields{
+				Id:           groupId,
+				Group:        GroupFields{Id: groupId, Name: groupName, Avatar: groupAvatar},
+				PostId:       id,
+				Author:       author,
+				AuthorImg:    authorImg,
+				Image:        image,
+				Text:         text,
+				Thread:       thread,
+				Time:         postTime,
+				PostComments: comments,
+				PostAuthor:   author == viewer.Nickname,
+				Mentions:     ResolveMentions(text),
+				Edited:       editedAt != 0,
+				EditedAt:     editedAt,
+			}
+			groupPost.Poll = GetItemPoll("group-post", groupPost.PostId, viewer.Nickname)
+			groupPost.Preview = GetLinkPreview("group-post", groupPost.PostId)
+			page.Items = append(page.Items, FeedItem{Kind: kind, GroupPost: &groupPost})
+		}
+		lastTime, lastId = postTime, id
 	}
 	rows.Close()
-	return sliceOfLikeRows
+	var posts []*PostFields
+	var groupPosts []*GroupPostFields
+	for _, item := range page.Items {
+		if item.Post != nil {
+			posts = append(posts, item.Post)
+		} else {
+			groupPosts = append(groupPosts, item.GroupPost)
+		}
+	}
+	LoadPostListReactions(posts, viewer.Nickname)
+	LoadGroupPostListReactions(groupPosts, viewer.Nickname)
+	// Posts hidden by CanView leave the page short, but a full query means more may follow.
+	if page.NextCursor == "" && scanned > limit {
+		page.NextCursor = EncodeCursor(strconv.Itoa(lastTime), lastId)
+	}
+	return page, nil
 }
 
-func AddPostLikes(postLiked LikesFields) error {
-	LikedPost := GetPostLike(postLiked.PostId, postLiked.Username)
+//
+// Bookmarks
+//
+
+var bookmarkTypes = []string{"post", "group-post"}
+
+// Save a post or group post, in a collection or unsorted when the collection is "".
+// Saving the same item in the same collection again keeps the original save time.
+func AddBookmark(owner string, bookmark BookmarkFields) error {
 	db := OpenDB()
 	defer db.Close()
-	var s string
-	if LikedPost.Like == "" {
-		s = "INSERT INTO likes (like, id, username) values (?, ?, ?)"
-	} else if postLiked.Like != LikedPost.Like {
-		s = "UPDATE likes SET like = ? WHERE id = ? AND username = ?"
-	} else {
-		s = "DELETE FROM likes WHERE like = ? AND id = ? AND username = ?"
+	_, err := db.Exec("INSERT OR IGNORE INTO bookmarks (owner, targetType, targetId, collectionId, created) VALUES (?, ?, ?, ?, ?)",
+		owner, bookmark.Type, bookmark.Id, bookmark.Collection, bookmark.Created)
+	if err != nil {
+		fmt.Println("error adding bookmark", err)
 	}
-	stmt, _ := db.Prepare(s)
-	_, err := stmt.Exec(postLiked.Like, postLiked.PostId, postLiked.Username)
+	return err
+}
+
+// Remove a saved item from a collection, or from everywhere when the collection is "*".
+func RemoveBookmark(owner string, bookmark BookmarkFields) error {
+	db := OpenDB()
+	defer db.Close()
+	_, err := db.Exec("DELETE FROM bookmarks WHERE owner = ? AND targetType = ? AND targetId = ? AND (? = '*' OR collectionId = ?)",
+		owner, bookmark.Type, bookmark.Id, bookmark.Collection, bookmark.Collection)
 	if err != nil {
-		fmt.Println(err)
+		fmt.Println("error removing bookmark", err)
 	}
 	return err
 }
 
-func GetPostLikes(id, l string) []LikesFields {
-	sliceOfLikedRows := []LikesFields{}
+// Called when a post or group post is removed.
+func RemoveItemBookmarks(targetType, targetId string) error {
 	db := OpenDB()
 	defer db.Close()
-	var s string
-	if l == "all" {
-		s = fmt.Sprintf("SELECT * FROM likes WHERE username = '%v' AND like = '%v'", id, "l")
+	_, err := db.Exec("DELETE FROM bookmarks WHERE targetType = ? AND targetId = ?", targetType, targetId)
+	if err != nil {
+		fmt.Println("error removing bookmarks", err)
+	}
+	return err
+}
 
-	} else {
-		s = fmt.Sprintf("SELECT * FROM likes WHERE id = '%v' AND like = '%v'", id, l)
+// Most saved items read for one page. Items are read in batches of twice the page size, and a
+// page of mostly hidden items ends early with a cursor rather than reading every saved item.
+const maxBookmarkScan = 500
+
+// Get a page of saved items, newest first. An empty collection lists every saved item once.
+// Visibility is checked again for every item, so items the owner may no longer see are skipped
+// but kept, and come back if the post becomes visible again.
+func GetBookmarks(owner, collection, cursor string, limit int) (FeedPage, error) {
+	page := FeedPage{Items: []FeedItem{}}
+	beforeCreated, beforeId := int64(math.MaxInt64), ""
+	if key, err := DecodeCursor(cursor); err != nil {
+		return page, err
+	} else if len(key) == 2 {
+		if beforeCreated, err = strconv.ParseInt(key[0], 10, 64); err != nil {
+			return page, err
+		}
+		beforeId = key[1]
+	}
 
+	db := OpenDB()
+	defer db.Close()
+	batch := limit * 2
+	scanned := 0
+	for {
+		rows, err := db.Query(`SELECT targetType, targetId, MAX(created) AS saved FROM bookmarks
+			WHERE owner = ? AND (? = '' OR collectionId = ?)
+			GROUP BY targetType, targetId
+			HAVING saved < ? OR (saved = ? AND targetId < ?)
+			ORDER BY saved DESC, targetId DESC LIMIT ?`, owner, collection, collection, beforeCreated, beforeCreated, beforeId, batch)
+		if err != nil {
+			fmt.Println("error getting bookmarks", err)
+			return page, err
+		}
+		read := 0
+		for rows.Next() {
+			var targetType, targetId string
+			var created int64
+			rows.Scan(&targetType, &targetId, &created)
+			if len(page.Items) == limit {
+				page.NextCursor = EncodeCursor(strconv.FormatInt(beforeCreated, 10), beforeId)
+				rows.Close()
+				return page, nil
+			}
+			read++
+			beforeCreated, beforeId = created, targetId
+			if targetType == "post" {
+				if post := GetPost(targetId, owner); post.Id != "" {
+					page.Items = append(page.Items, FeedItem{Kind: targetType, Post: &post})
+				}
+			} else if post := GetGroupPost(targetId, owner); post.PostId != "" {
+				post.Group = GetGroup(post.Id)
+				page.Items = append(page.Items, FeedItem{Kind: targetType, GroupPost: &post})
+			}
+		}
+		rows.Close()
+		scanned += read
+		if read < batch {
+			return page, nil
+		}
+		if scanned >= maxBookmarkScan {
+			// Hidden items left the page short, the rest is read with the next page.
+			page.NextCursor = EncodeCursor(strconv.FormatInt(beforeCreated, 10), beforeId)
+			return page, nil
+		}
 	}
+}
 
-	rows, _ := db.Query(s)
-	var postid string
-	var author string
-	var like string
+func GetCollections(owner string) []CollectionFields {
+	db := OpenDB()
+	defer db.Close()
+	sliceOfCollections := []CollectionFields{}
+	rows, err := db.Query(`SELECT c.id, c.owner, c.name, c.created, (SELECT COUNT(*) FROM bookmarks b WHERE b.owner = c.owner AND b.collectionId = c.id)
+		FROM collections c WHERE c.owner = ? ORDER BY c.name`, owner)
+	if err != nil {
+		fmt.Println("error getting collections", err)
+		return sliceOfCollections
+	}
 	for rows.Next() {
-		rows.Scan(&postid, &author, &like)
-		likedRows := LikesFields{
-			PostId:   postid,
-			Username: author,
-			Like:     like,
-		}
-		sliceOfLikedRows = append(sliceOfLikedRows, likedRows)
+		var collection CollectionFields
+		rows.Scan(&collection.Id, &collection.Owner, &collection.Name, &collection.Created, &collection.Saved)
+		sliceOfCollections = append(sliceOfCollections, collection)
 	}
 	rows.Close()
-	return sliceOfLikedRows
+	return sliceOfCollections
+}
+
+func GetCollection(collectionId string) CollectionFields {
+	var collection CollectionFields
+	db := OpenDB()
+	defer db.Close()
+	err := db.QueryRow("SELECT id, owner, name, created FROM collections WHERE id = ?", collectionId).Scan(&collection.Id, &collection.Owner, &collection.Name, &collection.Created)
+	if err != nil && err != sql.ErrNoRows {
+		fmt.Println("error getting collection", err)
+	}
+	return collection
+}
+
+// Create a collection, or rename it when it has an id. Names are unique per owner.
+func SaveCollection(collection CollectionFields) (CollectionFields, error) {
+	db := OpenDB()
+	defer db.Close()
+	var err error
+	if collection.Id == "" {
+		collection.Id = Generate()
+		collection.Created = int(time.Now().UnixMilli())
+		_, err = db.Exec("INSERT INTO collections (id, owner, name, created) VALUES (?, ?, ?, ?)", collection.Id, collection.Owner, collection.Name, collection.Created)
+	} else {
+		_, err = db.Exec("UPDATE collections SET name = ? WHERE id = ?", collection.Name, collection.Id)
+	}
+	if err != nil {
+		fmt.Println("error saving collection", err)
+	}
+	return collection, err
+}
+
+// Remove a collection and the items saved in it.
+func RemoveCollection(collectionId string) error {
+	db := OpenDB()
+	defer db.Close()
+	_, err := db.Exec("DELETE FROM bookmarks WHERE collectionId = ?", collectionId)
+	if err == nil {
+		_, err = db.Exec("DELETE FROM collections WHERE id = ?", collectionId)
+	}
+	if err != nil {
+		fmt.Println("error removing collection", err)
+	}
+	return err
 }
 
 //
-// Comments
+// Reposts
 //
 
-func AddComment(commentFields CommentFields) error {
-	fmt.Println("comments", commentFields)
+// SQL condition that is true when the viewer may see the post with the given alias, and its arguments.
+// The SQL form of CanView for posts, used where visibility has to be checked inside a query.
+func visiblePostSQL(alias string, viewer User) (string, []interface{}) {
+	return `(` + alias + `.author = ? OR ` + alias + `.privacy = 'public' OR
+		(` + alias + `.privacy = 'private' AND ` + alias + `.author IN (SELECT u.nickname FROM followers f JOIN users u ON u.email = f.followee WHERE f.follower = ?)) OR
+		(` + alias + `.privacy = 'almost-private' AND instr(',' || ` + alias + `.viewers || ',', ',' || ? || ',') > 0) OR
+		(` + alias + `.privacy = 'audience' AND ` + alias + `.viewers IN (SELECT audienceId FROM audienceMembers WHERE member = ?)))`,
+		[]interface{}{viewer.Nickname, viewer.Email, viewer.Nickname, viewer.Nickname}
+}
+
+// Fill in the repost fields of a post. The original is loaded as the viewer sees it, so an
+// original the viewer may not see comes back empty and CanView hides the repost too.
+func LoadRepost(post *PostFields, viewer string) {
+	db := OpenDB()
+	var originalId string
+	var originalDeleted bool
+	post.Kind = "post"
+	err := db.QueryRow("SELECT originalId, kind, originalDeleted FROM reposts WHERE postId = ?", post.Id).Scan(&originalId, &post.Kind, &originalDeleted)
+	if err != nil && err != sql.ErrNoRows {
+		fmt.Println("error getting repost", err)
+	}
+	db.QueryRow("SELECT COUNT(*) FROM reposts WHERE originalId = ?", post.Id).Scan(&post.Reposts)
+	db.Close()
+	if originalId == "" {
+		return
+	}
+	post.OriginalDeleted = originalDeleted
+	if originalDeleted {
+		// Tombstone, only the id of the deleted original is kept.
+		post.Original = &PostFields{Id: originalId}
+		return
+	}
+	original := GetPost(originalId, viewer)
+	post.Original = &original
+}
+
+func AddRepost(postFields PostFields, originalId, kind string) error {
+	if err := AddPost(postFields); err != nil {
+		return err
+	}
+	db := OpenDB()
+	defer db.Close()
+	_, err := db.Exec("INSERT INTO reposts (postId, originalId, kind, originalDeleted) VALUES (?, ?, ?, 0)", postFields.Id, originalId, kind)
+	if err != nil {
+		fmt.Println("error adding repost", err)
+	}
+	return err
+}
+
+// The plain repost of an original by a user, if there is one.
+func GetUserRepost(user, originalId string) string {
+	db := OpenDB()
+	defer db.Close()
+	var postId string
+	err := db.QueryRow("SELECT r.postId FROM reposts r JOIN posts p ON p.id = r.postId WHERE r.originalId = ? AND r.kind = 'repost' AND p.author = ?", originalId, user).Scan(&postId)
+	if err != nil && err != sql.ErrNoRows {
+		fmt.Println("error getting repost", err)
+	}
+	return postId
+}
+
+// Called when a post is removed: its reposts and quotes become tombstones.
+func RemoveRepost(postId string) {
+	db := OpenDB()
+	defer db.Close()
+	_, err := db.Exec("DELETE FROM reposts WHERE postId = ?", postId)
+	if err != nil {
+		fmt.Println("error removing repost", err)
+	}
+	_, err = db.Exec("UPDATE reposts SET originalDeleted = 1 WHERE originalId = ?", postId)
+	if err != nil {
+		fmt.Println("error updating reposts", err)
+	}
+}
+
+//
+// Drafts
+//
+
+// How often the scheduler looks for scheduled posts that are due.
+var SchedulerInterval = 15 * time.Second
+
+var postPrivacies = []string{"public", "private", "almost-private", "audience"}
+
+// Check that a draft could be published by its author. Returns the problem, or "" if there is none.
+// Checked when saving and again when publishing, as group membership and audiences can change.
+func DraftError(draft DraftFields) string {
+	if draft.Image == "" && draft.Text == "" && draft.Thread == "" {
+		return "please add content"
+	}
+	switch draft.Kind {
+	case "post":
+		if !Contains(postPrivacies, draft.Privacy) {
+			return "please choose who can see the post"
+		}
+		if draft.Privacy == "audience" && GetAudience(draft.Viewers).Owner != draft.Author {
+			return "please choose one of your audiences"
+		}
+	case "group-post":
+		if !IsGroupMember(draft.Author, draft.GroupId) {
+			return "You No Longer Have Access To This Group!"
+		}
+	default:
+		return "draft-type must be post or group-post"
+	}
+	return ""
+}
+
+func AddDraft(draft DraftFields) error {
+	draft.Image = StoreDataUrl(draft.Image, draft.Author)
+	db := OpenDB()
+	defer db.Close()
+	_, err := db.Exec(`INSERT INTO drafts (id, author, kind, groupId, image, text, thread, privacy, viewers, state, publishAt, created, updated, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, '')`, draft.Id, draft.Author, draft.Kind, draft.GroupId, draft.Image, draft.Text, draft.Thread,
+		draft.Privacy, draft.Viewers, draft.State, draft.PublishAt, draft.Created, draft.Updated)
+	if err != nil {
+		fmt.Println("error adding draft", err)
+	}
+	return err
+}
+
+// Drafts and scheduled posts being published cannot be edited any more.
+func UpdateDraft(draft DraftFields) error {
+	draft.Image = StoreDataUrl(draft.Image, draft.Author)
+	db := OpenDB()
+	defer db.Close()
+	result, err := db.Exec(`UPDATE drafts SET kind = ?, groupId = ?, image = ?, text = ?, thread = ?, privacy = ?, viewers = ?, state = ?, publishAt = ?, updated = ?, error = ''
+		WHERE id = ? AND author = ? AND state != 'publishing'`, draft.Kind, draft.GroupId, draft.Image, draft.Text, draft.Thread, draft.Privacy, draft.Viewers,
+		draft.State, draft.PublishAt, draft.Updated, draft.Id, draft.Author)
+	if err != nil {
+		fmt.Println("error updating draft", err)
+		return err
+	}
+	if changed, _ := result.RowsAffected(); changed == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func RemoveDraft(id, author string) error {
+	db := OpenDB()
+	defer db.Close()
+	result, err := db.Exec("DELETE FROM drafts WHERE id = ? AND author = ? AND state != 'publishing'", id, author)
+	if err != nil {
+		fmt.Println("error removing draft", err)
+		return err
+	}
+	if changed, _ := result.RowsAffected(); changed == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+const draftColumns = "id, author, kind, groupId, image, text, thread, privacy, viewers, state, publishAt, created, updated, error"
+
+func scanDrafts(rows *sql.Rows) []DraftFields {
+	sliceOfDrafts := []DraftFields{}
+	for rows.Next() {
+		var draft DraftFields
+		err := rows.Scan(&draft.Id, &draft.Author, &draft.Kind, &draft.GroupId, &draft.Image, &draft.Text, &draft.Thread,
+			&draft.Privacy, &draft.Viewers, &draft.State, &draft.PublishAt, &draft.Created, &draft.Updated, &draft.Error)
+		if err != nil {
+			fmt.Println("error reading draft", err)
+			continue
+		}
+		sliceOfDrafts = append(sliceOfDrafts, draft)
+	}
+	rows.Close()
+	return sliceOfDrafts
+}
+
+func GetDraft(id string) DraftFields {
+	db := OpenDB()
+	defer db.Close()
+	rows, err := db.Query("SELECT "+draftColumns+" FROM drafts WHERE id = ?", id)
+	if err != nil {
+		fmt.Println("error getting draft", err)
+		return DraftFields{}
+	}
+	drafts := scanDrafts(rows)
+	if len(drafts) == 0 {
+		return DraftFields{}
+	}
+	return drafts[0]
+}
+
+// Get the drafts of an author, scheduled ones first by publish time. An empty state returns all of them.
+func GetDrafts(author, state string) []DraftFields {
+	db := OpenDB()
+	defer db.Close()
+	rows, err := db.Query("SELECT "+draftColumns+" FROM drafts WHERE author = ? AND (? = '' OR state = ?) ORDER BY publishAt = 0, publishAt, updated DESC", author, state, state)
+	if err != nil {
+		fmt.Println("error getting drafts", err)
+		return []DraftFields{}
+	}
+	return scanDrafts(rows)
+}
+
+// Publish scheduled posts that are due, in the background. Drafts live in the database so nothing
+// is lost on restart; ones that were being published when the server stopped are retried.
+func RunScheduler() {
+	db := OpenDB()
+	_, err := db.Exec("UPDATE drafts SET state = 'scheduled' WHERE state = 'publishing'")
+	if err != nil {
+		fmt.Println("error recovering scheduled posts", err)
+	}
+	db.Close()
+	for {
+		PublishDueDrafts()
+		AnnounceClosedPolls()
+		PurgeExpiredStories()
+		PurgeExpiredMutes()
+		time.Sleep(SchedulerInterval)
+	}
+}
+
+func PublishDueDrafts() {
+	db := OpenDB()
+	rows, err := db.Query("SELECT "+draftColumns+" FROM drafts WHERE state = 'scheduled' AND publishAt <= ? ORDER BY publishAt", time.Now().UnixMilli())
+	if err != nil {
+		fmt.Println("error getting scheduled posts", err)
+		db.Close()
+		return
+	}
+	due := scanDrafts(rows)
+	db.Close()
+	for _, draft := range due {
+		PublishDraft(draft)
+	}
+}
+
+// Publish a scheduled post as its author would through create-post or create-group-post.
+// The draft id becomes the post id, so publishing twice after a crash adds the post only once.
+func PublishDraft(draft DraftFields) error {
+	db := OpenDB()
+	defer db.Close()
+	result, err := db.Exec("UPDATE drafts SET state = 'publishing' WHERE id = ? AND state = 'scheduled'", draft.Id)
+	if err != nil {
+		fmt.Println("error publishing draft", err)
+		return err
+	}
+	if claimed, _ := result.RowsAffected(); claimed == 0 {
+		// Edited, cancelled or published in the meantime.
+		return nil
+	}
+	// A failed draft stays failed with the reason shown to its author, rather than being retried
+	// by every run of the scheduler, until the author edits or reschedules it.
+	fail := func(problem string) error {
+		_, err := db.Exec("UPDATE drafts SET state = 'failed', error = ? WHERE id = ?", problem, draft.Id)
+		if err != nil {
+			fmt.Println("error updating draft", err)
+		}
+		return errors.New(problem)
+	}
+	if problem := DraftError(draft); problem != "" {
+		return fail(problem)
+	}
+
+	if draft.Kind == "post" {
+		if GetPost(draft.Id, draft.Author).Id == "" {
+			err := AddPost(PostFields{
+				Id:      draft.Id,
+				Author:  draft.Author,
+				Image:   draft.Image,
+				Text:    draft.Text,
+				Thread:  draft.Thread,
+				Time:    draft.PublishAt,
+				Privacy: draft.Privacy,
+				Viewers: draft.Viewers,
+			})
+			if err != nil {
+				return fail("the post could not be published: " + err.Error())
+			}
+		}
+	} else if GetGroupPost(draft.Id, draft.Author).PostId == "" {
+		postFields := GroupPostFields{
+			Id:     draft.GroupId,
+			PostId: draft.Id,
+			Author: draft.Author,
+			Image:  draft.Image,
+			Text:   draft.Text,
+			Thread: draft.Thread,
+			Time:   draft.PublishAt,
+		}
+		if err := AddGroupPost(postFields); err != nil {
+			return fail("the post could not be published: " + err.Error())
+		}
+		BroadcastGroupPost(GetGroupPost(draft.Id, draft.Author))
+	}
+	// The draft is the only copy of the post until the post is stored.
+	if (draft.Kind == "post" && GetPost(draft.Id, draft.Author).Id == "") || (draft.Kind != "post" && GetGroupPost(draft.Id, draft.Author).PostId == "") {
+		return fail("the post could not be published")
+	}
+	_, err = db.Exec("DELETE FROM drafts WHERE id = ?", draft.Id)
+	if err != nil {
+		fmt.Println("error removing published draft", err)
+	}
+	return err
+}
+
+//
+// Revisions
+//
+
+// Store an edit. The first edit also stores the original version, so the revisions of an item
+// are its full history, oldest first. Edits that change nothing are not stored.
+func SaveRevision(current, edited RevisionFields) error {
+	if current.Id == "" || (current.Image == edited.Image && current.Text == edited.Text && current.Thread == edited.Thread) {
+		return nil
+	}
+	db := OpenDB()
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		fmt.Println("error saving revision", err)
+		return err
+	}
+	defer tx.Rollback()
+	var revisions int
+	tx.QueryRow("SELECT COUNT(*) FROM revisions WHERE targetType = ? AND targetId = ?", current.Type, current.Id).Scan(&revisions)
+	if revisions == 0 {
+		_, err = tx.Exec("INSERT INTO revisions (targetType, targetId, author, image, text, thread, time) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			current.Type, current.Id, current.Author, current.Image, current.Text, current.Thread, current.Time)
+		if err != nil {
+			fmt.Println("error adding revision", err)
+			return err
+		}
+	}
+	_, err = tx.Exec("INSERT INTO revisions (targetType, targetId, author, image, text, thread, time) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		current.Type, current.Id, current.Author, edited.Image, edited.Text, edited.Thread, time.Now().UnixMilli())
+	if err != nil {
+		fmt.Println("error adding revision", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+func GetRevisions(targetType, targetId string) []RevisionFields {
+	db := OpenDB()
+	defer db.Close()
+	sliceOfRevisions := []RevisionFields{}
+	rows, err := db.Query("SELECT targetType, targetId, author, image, text, thread, time FROM revisions WHERE targetType = ? AND targetId = ? ORDER BY time, rowid", targetType, targetId)
+	if err != nil {
+		fmt.Println("error getting revisions", err)
+		return sliceOfRevisions
+	}
+	for rows.Next() {
+		var revision RevisionFields
+		rows.Scan(&revision.Type, &revision.Id, &revision.Author, &revision.Image, &revision.Text, &revision.Thread, &revision.Time)
+		sliceOfRevisions = append(sliceOfRevisions, revision)
+	}
+	rows.Close()
+	return sliceOfRevisions
+}
+
+// Time of the last edit of an item, 0 if it was never edited.
+func GetLastEdit(targetType, targetId string) int {
+	db := OpenDB()
+	defer db.Close()
+	var lastEdit int
+	err := db.QueryRow("SELECT IFNULL(MAX(time), 0) FROM revisions WHERE targetType = ? AND targetId = ?", targetType, targetId).Scan(&lastEdit)
+	if err != nil {
+		fmt.Println("error getting last edit", err)
+	}
+	return lastEdit
+}
+
+func RemoveRevisions(targetType, targetId string) error {
+	db := OpenDB()
+	defer db.Close()
+	_, err := db.Exec("DELETE FROM revisions WHERE targetType = ? AND targetId = ?", targetType, targetId)
+	if err != nil {
+		fmt.Println("error removing revisions", err)
+	}
+	return err
+}
+
+//
+// Mentions
+//
+
+// Find the @nickname candidates of a text. An @ directly after a letter or digit, as in an
+// email address, does not start a mention.
+func ParseMentions(text string) []MentionSpan {
+	spans := []MentionSpan{}
+	runes := []rune(text)
+	offset := 0
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r == '@' && (i == 0 || !isMentionRune(runes[i-1])) {
+			end := i + 1
+			for end < len(runes) && isMentionRune(runes[end]) {
+				end++
+			}
+			// Trailing punctuation belongs to the sentence, not the nickname.
+			for end > i+1 && strings.ContainsRune(".-", runes[end-1]) {
+				end--
+			}
+			if end > i+1 {
+				nickname := string(runes[i+1 : end])
+				spans = append(spans, MentionSpan{Nickname: nickname, Start: offset, End: offset + utf16Length(runes[i:end])})
+				offset += utf16Length(runes[i:end])
+				i = end - 1
+				continue
+			}
+		}
+		offset += utf16Length(runes[i : i+1])
+	}
+	return spans
+}
+
+func isMentionRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_.-", r)
+}
+
+func utf16Length(runes []rune) int {
+	return len(utf16.Encode(runes))
+}
+
+// Mention spans of a text that resolve to existing users.
+func ResolveMentions(text string) []MentionSpan {
+	if !strings.Contains(text, "@") {
+		return nil
+	}
+	candidates := ParseMentions(text)
+	if len(candidates) == 0 {
+		return nil
+	}
+	placeholders := make([]string, len(candidates))
+	args := make([]interface{}, len(candidates))
+	for i, span := range candidates {
+		placeholders[i] = "?"
+		args[i] = span.Nickname
+	}
+	db := OpenDB()
+	defer db.Close()
+	rows, err := db.Query("SELECT nickname FROM users WHERE nickname IN ("+strings.Join(placeholders, ",")+")", args...)
+	if err != nil {
+		fmt.Println("error resolving mentions", err)
+		return nil
+	}
+	users := map[string]bool{}
+	var nickname string
+	for rows.Next() {
+		rows.Scan(&nickname)
+		users[nickname] = true
+	}
+	rows.Close()
+	var spans []MentionSpan
+	for _, span := range candidates {
+		if users[span.Nickname] {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+// Store the mentions of a post, comment, group post, group comment or chat message and notify
+// the users mentioned for the first time. Users who cannot see the content are not mentioned.
+// Context is the post, group or chatroom the content belongs to.
+// Mentions removed by an edit are deactivated rather than deleted so adding them back does not notify again.
+func SaveMentions(targetType, targetId, context, author, text string, mentionTime int, canSee func(user string) bool) {
+	mentioned := []string{}
+	for _, span := range ResolveMentions(text) {
+		if span.Nickname != author && !Contains(mentioned, span.Nickname) && canSee(span.Nickname) {
+			mentioned = append(mentioned, span.Nickname)
+		}
+	}
+	if mentionTime == 0 {
+		mentionTime = int(time.Now().UnixMilli())
+	}
+
+	db := OpenDB()
+	previous := map[string]bool{}
+	rows, err := db.Query("SELECT mentioned FROM mentions WHERE targetType = ? AND targetId = ?", targetType, targetId)
+	if err != nil {
+		fmt.Println("error getting mentions", err)
+		db.Close()
+		return
+	}
+	var nickname string
+	for rows.Next() {
+		rows.Scan(&nickname)
+		previous[nickname] = true
+	}
+	rows.Close()
+
+	_, err = db.Exec("UPDATE mentions SET active = 0 WHERE targetType = ? AND targetId = ?", targetType, targetId)
+	if err != nil {
+		fmt.Println("error updating mentions", err)
+	}
+	var notifications []MentionNotification
+	for _, user := range mentioned {
+		if previous[user] {
+			db.Exec("UPDATE mentions SET active = 1 WHERE targetType = ? AND targetId = ? AND mentioned = ?", targetType, targetId, user)
+			continue
+		}
+		_, err := db.Exec("INSERT INTO mentions (targetType, targetId, context, author, mentioned, time, seen, active) VALUES (?, ?, ?, ?, ?, ?, 0, 1)",
+			targetType, targetId, context, author, user, mentionTime)
+		if err != nil {
+			fmt.Println("error adding mention", err)
+			continue
+		}
+		notifications = append(notifications, MentionNotification{
+			Type:     targetType,
+			Id:       targetId,
+			Context:  context,
+			Author:   author,
+			Receiver: user,
+			Time:     mentionTime,
+		})
+	}
+	db.Close()
+
+	for _, notification := range notifications {
+		if IsMutedContent(notification.Receiver, author, "", "") {
+			continue
+		}
+		H.SendTo([]string{notification.Receiver}, notification)
+	}
+}
+
+func RemoveMentions(targetType, targetId string) error {
+	db := OpenDB()
+	defer db.Close()
+	_, err := db.Exec("DELETE FROM mentions WHERE targetType = ? AND targetId = ?", targetType, targetId)
+	if err != nil {
+		fmt.Println("error removing mentions", err)
+	}
+	return err
+}
+
+// Get the mentions of a user, newest first. Mentions by muted users are hidden but kept in the table.
+func GetMentionNotifications(user string, limit int) []MentionNotification {
+	db := OpenDB()
+	defer db.Close()
+	sliceOfMentions := []MentionNotification{}
+	rows, err := db.Query(`SELECT targetType, targetId, context, author, time, seen FROM mentions
+		WHERE mentioned = ? AND active = 1 AND author NOT IN (SELECT muted FROM mutes WHERE user = ? AND type = 'user' AND (expires = 0 OR expires > ?))
+		ORDER BY time DESC LIMIT ?`, user, user, time.Now().UnixMilli(), limit)
+	if err != nil {
+		fmt.Println("error getting mentions", err)
+		return sliceOfMentions
+	}
+	for rows.Next() {
+		mention := MentionNotification{Receiver: user}
+		rows.Scan(&mention.Type, &mention.Id, &mention.Context, &mention.Author, &mention.Time, &mention.Seen)
+		sliceOfMentions = append(sliceOfMentions, mention)
+	}
+	rows.Close()
+	return sliceOfMentions
+}
+
+// Mark mentions as seen. An empty target id marks all of the user's mentions.
+func MarkMentionsSeen(user, targetType, targetId string) error {
+	db := OpenDB()
+	defer db.Close()
+	var err error
+	if targetId == "" {
+		_, err = db.Exec("UPDATE mentions SET seen = 1 WHERE mentioned = ?", user)
+	} else {
+		_, err = db.Exec("UPDATE mentions SET seen = 1 WHERE mentioned = ? AND targetType = ? AND targetId = ?", user, targetType, targetId)
+	}
+	if err != nil {
+		fmt.Println("error updating mentions", err)
+	}
+	return err
+}
+
+//
+// Hashtags
+//
+
+const maxTagLength = 50
+const maxTagsPerItem = 30
+
+// Tags are only counted towards trending and autocomplete when they are linked to content
+// everyone can see, so tags used in private posts and groups are never revealed.
+const publicTagLinkFilter = `((l.targetType = 'post' AND EXISTS (SELECT 1 FROM posts p WHERE p.id = l.targetId AND p.privacy = 'public')) OR
+	(l.targetType = 'comment' AND EXISTS (SELECT 1 FROM comments c JOIN posts p ON p.id = c.postid WHERE c.id = l.targetId AND p.privacy = 'public')))`
+
+// Normalise a single hashtag: lower case letters, digits and underscores without the leading '#'.
+func NormaliseTag(tag string) string {
+	var name []rune
+	for _, r := range strings.ToLower(tag) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			name = append(name, r)
+		}
+	}
+	if len(name) > maxTagLength {
+		name = name[:maxTagLength]
+	}
+	return string(name)
+}
+
+// Parse the hashtags of a thread field. Tags may be separated by '#', commas or spaces.
+func ParseHashtags(thread string) []string {
+	tags := []string{}
+	for _, field := range strings.FieldsFunc(thread, func(r rune) bool {
+		return r == '#' || r == ',' || unicode.IsSpace(r)
+	}) {
+		tag := NormaliseTag(field)
+		if tag != "" && !Contains(tags, tag) && len(tags) < maxTagsPerItem {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Rewrite a thread field in the "#a,#b" form the frontend and mutes expect.
+func NormaliseThread(thread string) string {
+	tags := ParseHashtags(thread)
+	for i, tag := range tags {
+		tags[i] = "#" + tag
+	}
+	return strings.Join(tags, ",")
+}
+
+// Replace the tag links of a post, comment, group post or group comment with the tags in thread.
+// A zero time keeps the time of the existing links, so edits do not bump a tag up the trending list.
+func SaveTags(targetType, targetId, thread string, linkTime int) error {
+	db := OpenDB()
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		fmt.Println("error saving tags", err)
+		return err
+	}
+	defer tx.Rollback()
+	if linkTime == 0 {
+		tx.QueryRow("SELECT IFNULL(MAX(time), 0) FROM tagLinks WHERE targetType = ? AND targetId = ?", targetType, targetId).Scan(&linkTime)
+	}
+	if linkTime == 0 {
+		linkTime = int(time.Now().UnixMilli())
+	}
+	if _, err = tx.Exec("DELETE FROM tagLinks WHERE targetType = ? AND targetId = ?", targetType, targetId); err != nil {
+		fmt.Println("error removing tag links", err)
+		return err
+	}
+	for _, tag := range ParseHashtags(thread) {
+		if _, err = tx.Exec("INSERT OR IGNORE INTO tags (name) VALUES (?)", tag); err != nil {
+			fmt.Println("error adding tag", err)
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO tagLinks (tagId, targetType, targetId, time) SELECT id, ?, ?, ? FROM tags WHERE name = ?", targetType, targetId, linkTime, tag)
+		if err != nil {
+			fmt.Println("error adding tag link", err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func RemoveTags(targetType, targetId string) error {
+	db := OpenDB()
+	defer db.Close()
+	_, err := db.Exec("DELETE FROM tagLinks WHERE targetType = ? AND targetId = ?", targetType, targetId)
+	if err != nil {
+		fmt.Println("error removing tag links", err)
+	}
+	return err
+}
+
+// Tags starting with prefix, most used first.
+func AutocompleteTags(prefix string, limit int) []TagFields {
+	return queryTags(`SELECT t.name, COUNT(*) AS uses FROM tags t JOIN tagLinks l ON l.tagId = t.id
+		WHERE substr(t.name, 1, length(?)) = ? AND `+publicTagLinkFilter+`
+		GROUP BY t.id ORDER BY uses DESC, t.name LIMIT ?`, prefix, prefix, limit)
+}
+
+// Tags used most often in the window ending now.
+func GetTrendingTags(window time.Duration, limit int) []TagFields {
+	since := time.Now().Add(-window).UnixMilli()
+	return queryTags(`SELECT t.name, COUNT(*) AS uses FROM tagLinks l JOIN tags t ON t.id = l.tagId
+		WHERE l.time >= ? AND `+publicTagLinkFilter+`
+		GROUP BY t.id ORDER BY uses DESC, t.name LIMIT ?`, since, limit)
+}
+
+func queryTags(query string, args ...interface{}) []TagFields {
+	db := OpenDB()
+	defer db.Close()
+	sliceOfTags := []TagFields{}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		fmt.Println("error getting tags", err)
+		return sliceOfTags
+	}
+	var name string
+	var uses int
+	for rows.Next() {
+		rows.Scan(&name, &uses)
+		sliceOfTags = append(sliceOfTags, TagFields{Name: name, Count: uses})
+	}
+	rows.Close()
+	return sliceOfTags
+}
+
+// Parse the threads of content written before tags were stored. Only runs while tagLinks is empty.
+func MigrateTags() {
+	db := OpenDB()
+	var links int
+	db.QueryRow("SELECT COUNT(*) FROM tagLinks").Scan(&links)
+	if links > 0 {
+		db.Close()
+		return
+	}
+	type taggedRow struct {
+		targetType, table, idColumn, id, thread string
+		time                                    int
+	}
+	var taggedRows []taggedRow
+	for _, source := range []taggedRow{
+		{targetType: "post", table: "posts", idColumn: "id"},
+		{targetType: "comment", table: "comments", idColumn: "id"},
+		{targetType: "group-post", table: "groupposts", idColumn: "postid"},
+		{targetType: "group-comment", table: "groupComments", idColumn: "id"},
+	} {
+		rows, err := db.Query("SELECT " + source.idColumn + ", IFNULL(thread, ''), IFNULL(time, 0) FROM " + source.table + " WHERE IFNULL(thread, '') != ''")
+		if err != nil {
+			fmt.Println("error reading threads", err)
+			continue
+		}
+		for rows.Next() {
+			row := source
+			rows.Scan(&row.id, &row.thread, &row.time)
+			taggedRows = append(taggedRows, row)
+		}
+		rows.Close()
+	}
+	for _, row := range taggedRows {
+		_, err := db.Exec("UPDATE "+row.table+" SET thread = ? WHERE "+row.idColumn+" = ?", NormaliseThread(row.thread), row.id)
+		if err != nil {
+			fmt.Println("error normalising thread", err)
+		}
+	}
+	db.Close()
+	for _, row := range taggedRows {
+		SaveTags(row.targetType, row.id, row.thread, row.time)
+	}
+}
+
+//
+// Audiences
+//
+
+const closeFriendsAudience = "Close Friends"
+
+// Create the built-in close friends list for a user if they do not have one yet.
+func EnsureCloseFriends(owner string) {
+	db := OpenDB()
+	defer db.Close()
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM audiences WHERE owner = ? AND builtIn = 1", owner).Scan(&count)
+	if count == 0 {
+		_, err := db.Exec("INSERT INTO audiences (id, owner, name, builtIn) values (?, ?, ?, 1)", Generate(), owner, closeFriendsAudience)
+		if err != nil {
+			fmt.Println("error adding close friends audience", err)
+		}
+	}
+}
+
+func GetAudiences(owner string) []AudienceFields {
+	EnsureCloseFriends(owner)
+	db := OpenDB()
+	defer db.Close()
+	sliceOfAudiences := []AudienceFields{}
+	rows, err := db.Query("SELECT id FROM audiences WHERE owner = ? ORDER BY builtIn DESC, name", owner)
+	if err != nil {
+		fmt.Println("error getting audiences", err)
+		return sliceOfAudiences
+	}
+	var ids []string
+	var id string
+	for rows.Next() {
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	for _, id := range ids {
+		sliceOfAudiences = append(sliceOfAudiences, GetAudience(id))
+	}
+	return sliceOfAudiences
+}
+
+func GetAudience(audienceId string) AudienceFields {
+	db := OpenDB()
+	defer db.Close()
+	var audience AudienceFields
+	err := db.QueryRow("SELECT id, owner, name, builtIn FROM audiences WHERE id = ?", audienceId).
+		Scan(&audience.Id, &audience.Owner, &audience.Name, &audience.BuiltIn)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			fmt.Println("error getting audience", err)
+		}
+		return AudienceFields{}
+	}
+	audience.Members = []string{}
+	rows, err := db.Query("SELECT member FROM audienceMembers WHERE audienceId = ? ORDER BY member", audienceId)
+	if err != nil {
+		fmt.Println("error getting audience members", err)
+		return audience
+	}
+	var member string
+	for rows.Next() {
+		rows.Scan(&member)
+		audience.Members = append(audience.Members, member)
+	}
+	rows.Close()
+	return audience
+}
+
+// Add a new audience, or rename and replace the members of an existing one.
+func SaveAudience(audience AudienceFields) (AudienceFields, error) {
+	db := OpenDB()
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return audience, err
+	}
+	if audience.Id == "" {
+		audience.Id = Generate()
+		_, err = tx.Exec("INSERT INTO audiences (id, owner, name, builtIn) values (?, ?, ?, 0)", audience.Id, audience.Owner, audience.Name)
+	} else if !audience.BuiltIn {
+		_, err = tx.Exec("UPDATE audiences SET name = ? WHERE id = ?", audience.Name, audience.Id)
+	}
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM audienceMembers WHERE audienceId = ?", audience.Id)
+	}
+	for _, member := range audience.Members {
+		if err == nil {
+			_, err = tx.Exec("INSERT INTO audienceMembers (audienceId, member) values (?, ?)", audience.Id, member)
+		}
+	}
+	if err != nil {
+		fmt.Println("error saving audience", err)
+		tx.Rollback()
+		return audience, err
+	}
+	return audience, tx.Commit()
+}
+
+func RemoveAudience(audienceId string) error {
+	db := OpenDB()
+	defer db.Close()
+	_, err := db.Exec("DELETE FROM audienceMembers WHERE audienceId = ?", audienceId)
+	if err == nil {
+		_, err = db.Exec("DELETE FROM audiences WHERE id = ? AND builtIn = 0", audienceId)
+	}
+	if err != nil {
+		fmt.Println("error removing audience", err)
+	}
+	return err
+}
+
+// Check whether the user is currently on the audience list.
+func IsAudienceMember(audienceId, user string) bool {
+	db := OpenDB()
+	defer db.Close()
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM audienceMembers WHERE audienceId = ? AND member = ?", audienceId, user).Scan(&count)
+	return count > 0
+}
+
+//
+// Reactions
+//
+
+// Reactions users can leave on posts and comments. REACTIONS overrides them with a comma
+// separated list; like and dislike also feed the like and dislike counts older clients read.
+var ReactionTypes = reactionTypesFromEnv()
+
+var reactionTargets = []string{"post", "comment", "group-post", "group-comment"}
+
+// Values sent by the like/dislike interactions before reactions existed.
+var legacyReactions = map[string]string{"l": "like", "d": "dislike"}
+
+var errUnknownReaction = errors.New("unknown reaction")
+
+func reactionTypesFromEnv() []string {
+	list := os.Getenv("REACTIONS")
+	if list == "" {
+		return []string{"like", "dislike", "love", "laugh", "wow", "sad", "angry"}
+	}
+	var reactions []string
+	for _, reaction := range strings.Split(list, ",") {
+		reaction = strings.ToLower(strings.TrimSpace(reaction))
+		if reaction != "" && !Contains(reactions, reaction) {
+			reactions = append(reactions, reaction)
+		}
+	}
+	return reactions
+}
+
+// Map the older "l" and "d" like values onto reaction names.
+func ReactionName(value string) string {
+	if reaction, ok := legacyReactions[value]; ok {
+		return reaction
+	}
+	return value
+}
+
+// Set user's reaction to a post, comment, group post or group comment. Each user has at most
+// one reaction per item: a different reaction replaces it and the same one again removes it.
+func AddReaction(targetType, targetId, user, reaction string) error {
+	reaction = ReactionName(reaction)
+	if !Contains(reactionTargets, targetType) || !Contains(ReactionTypes, reaction) {
+		return errUnknownReaction
+	}
+	db := OpenDB()
+	defer db.Close()
+	var current string
+	db.QueryRow("SELECT reaction FROM reactions WHERE targetType = ? AND targetId = ? AND user = ?", targetType, targetId, user).Scan(&current)
+	var err error
+	if current == reaction {
+		_, err = db.Exec("DELETE FROM reactions WHERE targetType = ? AND targetId = ? AND user = ?", targetType, targetId, user)
+	} else {
+		_, err = db.Exec(`INSERT INTO reactions (targetType, targetId, user, reaction, time) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (targetType, targetId, user) DO UPDATE SET reaction = excluded.reaction, time = excluded.time`,
+			targetType, targetId, user, reaction, time.Now().UnixMilli())
+	}
+	if err != nil {
+		fmt.Println("error saving reaction", err)
+	}
+	return err
+}
+
+// Get the count of every reaction on an item, including those nobody used yet,
+// and the reaction user left on it, "" if none.
+func GetReactions(targetType, targetId, user string) (map[string]int, string) {
+	reactions := GetReactionsFor(targetType, []string{targetId}, user)[targetId]
+	return reactions.Counts, reactions.Own
+}
+
+// Most items whose reactions are read in one query, below SQLite's limit on query parameters.
+const reactionBatchSize = 500
+
+// Reaction counts of an item and the reaction the viewer left on it.
+type ItemReactions struct {
+	Counts map[string]int
+	Own    string
+}
+
+// Get the reactions of many items of one type, keyed by item id, so a listing reads them
+// in one query instead of one per item.
+func GetReactionsFor(targetType string, targetIds []string, user string) map[string]ItemReactions {
+	reactions := map[string]ItemReactions{}
+	for _, id := range targetIds {
+		counts := map[string]int{}
+		for _, reaction := range ReactionTypes {
+			counts[reaction] = 0
+		}
+		reactions[id] = ItemReactions{Counts: counts}
+	}
+	if len(targetIds) == 0 {
+		return reactions
+	}
+	db := OpenDB()
+	defer db.Close()
+	for start := 0; start < len(targetIds); start += reactionBatchSize {
+		end := start + reactionBatchSize
+		if end > len(targetIds) {
+			end = len(targetIds)
+		}
+		args := []interface{}{user, targetType}
+		for _, id := range targetIds[start:end] {
+			args = append(args, id)
+		}
+		rows, err := db.Query("SELECT targetId, reaction, COUNT(*), MAX(user = ?) FROM reactions WHERE targetType = ? AND targetId IN (?"+
+			strings.Repeat(", ?", end-start-1)+") GROUP BY targetId, reaction", args...)
+		if err != nil {
+			fmt.Println("error getting reactions", err)
+			return reactions
+		}
+		for rows.Next() {
+			var id, reaction string
+			var count int
+			var mine bool
+			rows.Scan(&id, &reaction, &count, &mine)
+			// Reactions taken out of the configured set are kept but no longer shown.
+			if !Contains(ReactionTypes, reaction) {
+				continue
+			}
+			item := reactions[id]
+			item.Counts[reaction] = count
+			if mine {
+				item.Own = reaction
+			}
+			reactions[id] = item
+		}
+		rows.Close()
+	}
+	return reactions
+}
+
+func LoadPostReactions(post *PostFields, user string) {
+	LoadPostListReactions([]*PostFields{post}, user)
+}
+
+func LoadGroupPostReactions(post *GroupPostFields, user string) {
+	LoadGroupPostListReactions([]*GroupPostFields{post}, user)
+}
+
+// Target type is comment or group-comment.
+func LoadCommentReactions(comment *CommentFields, targetType, user string) {
+	LoadCommentListReactions([]*CommentFields{comment}, targetType, user)
+}
+
+func LoadPostListReactions(posts []*PostFields, user string) {
+	var ids []string
+	for _, post := range posts {
+		ids = append(ids, post.Id)
+	}
+	reactions := GetReactionsFor("post", ids, user)
+	for _, post := range posts {
+		post.Reactions, post.Reaction = reactions[post.Id].Counts, reactions[post.Id].Own
+		post.Likes, post.Dislikes = post.Reactions["like"], post.Reactions["dislike"]
+		post.PostLiked, post.PostDisliked = post.Reaction == "like", post.Reaction == "dislike"
+	}
+}
+
+func LoadGroupPostListReactions(posts []*GroupPostFields, user string) {
+	var ids []string
+	for _, post := range posts {
+		ids = append(ids, post.PostId)
+	}
+	reactions := GetReactionsFor("group-post", ids, user)
+	for _, post := range posts {
+		post.Reactions, post.Reaction = reactions[post.PostId].Counts, reactions[post.PostId].Own
+		post.Likes, post.Dislikes = post.Reactions["like"], post.Reactions["dislike"]
+		post.PostLiked, post.PostDisliked = post.Reaction == "like", post.Reaction == "dislike"
+	}
+}
+
+func LoadCommentListReactions(comments []*CommentFields, targetType, user string) {
+	var ids []string
+	for _, comment := range comments {
+		ids = append(ids, comment.CommentId)
+	}
+	reactions := GetReactionsFor(targetType, ids, user)
+	for _, comment := range comments {
+		comment.Reactions, comment.Reaction = reactions[comment.CommentId].Counts, reactions[comment.CommentId].Own
+		comment.Likes, comment.Dislikes = comment.Reactions["like"], comment.Reactions["dislike"]
+		comment.CommentLiked, comment.CommentDisliked = comment.Reaction == "like", comment.Reaction == "dislike"
+	}
+}
+
+func RemoveReactions(targetType, targetId string) {
+	db := OpenDB()
+	defer db.Close()
+	_, err := db.Exec("DELETE FROM reactions WHERE targetType = ? AND targetId = ?", targetType, targetId)
+	if err != nil {
+		fmt.Println("error removing reactions", err)
+	}
+}
+
+// Move the likes kept in the old likes, likescom and likesgroup tables into reactions
+// and drop those tables. Tables already migrated no longer exist and are skipped.
+func MigrateLikes() {
+	db := OpenDB()
+	defer db.Close()
+	for _, source := range []struct{ table, targetType string }{
+		{"likes", "post"},
+		{"likescom", "comment"},
+		{"likesgroup", "group-post"},
+	} {
+		var exists int
+		db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", source.table).Scan(&exists)
+		if exists == 0 {
+			continue
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			fmt.Println("error migrating likes", err)
+			return
+		}
+		_, err = tx.Exec("INSERT OR IGNORE INTO reactions (targetType, targetId, user, reaction, time) SELECT ?, `id`, `username`, CASE `like` WHEN 'l' THEN 'like' ELSE 'dislike' END, 0 FROM `"+source.table+"` WHERE `like` IN ('l', 'd')", source.targetType)
+		if err == nil {
+			_, err = tx.Exec("DROP TABLE `" + source.table + "`")
+		}
+		if err != nil {
+			fmt.Println("error migrating likes from", source.table, err)
+			tx.Rollback()
+			continue
+		}
+		tx.Commit()
+	}
+}
+
+//
+// Polls
+//
+
+const maxPollOptions = 10
+
+var errPollClosed = errors.New("poll closed")
+var errAlreadyVoted = errors.New("already voted")
+var errInvalidBallot = errors.New("invalid ballot")
+
+// Check a poll sent with a new post or group post, "" if it can be added.
+func PollError(poll PollFields) string {
+	if len(poll.Options) < 2 || len(poll.Options) > maxPollOptions {
+		return fmt.Sprintf("a poll needs between 2 and %v options", maxPollOptions)
+	}
+	for _, option := range poll.Options {
+		if strings.TrimSpace(option.Text) == "" {
+			return "poll options cannot be empty"
+		}
+	}
+	if poll.ClosesAt != 0 && int64(poll.ClosesAt) <= time.Now().UnixMilli() {
+		return "the poll must close in the future"
+	}
+	return ""
+}
+
+// Add the poll carried by a post or group post. Group polls keep the group so results
+// can be pushed to its room.
+func AddPoll(targetType, targetId, groupId string, poll PollFields) error {
+	db := OpenDB()
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		fmt.Println("error adding poll", err)
+		return err
+	}
+	pollId := Generate()
+	_, err = tx.Exec("INSERT INTO polls (id, targetType, targetId, groupId, multiple, closesAt, hideResults, announced) VALUES (?, ?, ?, ?, ?, ?, ?, 0)",
+		pollId, targetType, targetId, groupId, poll.Multiple, poll.ClosesAt, poll.HideResults)
+	for i, option := range poll.Options {
+		if err != nil {
+			break
+		}
+		_, err = tx.Exec("INSERT INTO pollOptions (pollId, id, text, position) VALUES (?, ?, ?, ?)", pollId, strconv.Itoa(i+1), strings.TrimSpace(option.Text), i)
+	}
+	if err != nil {
+		fmt.Println("error adding poll", err)
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+const pollColumns = "id, targetType, targetId, groupId, multiple, closesAt, hideResults"
+
+// Get the poll of a post or group post with its results as user sees them, nil if it has none.
+func GetItemPoll(targetType, targetId, user string) *PollFields {
+	db := OpenDB()
+	defer db.Close()
+	poll, ok := scanPoll(db, db.QueryRow("SELECT "+pollColumns+" FROM polls WHERE targetType = ? AND targetId = ?", targetType, targetId), user)
+	if !ok {
+		return nil
+	}
+	return &poll
+}
+
+func GetPoll(pollId, user string) (PollFields, bool) {
+	db := OpenDB()
+	defer db.Close()
+	return scanPoll(db, db.QueryRow("SELECT "+pollColumns+" FROM polls WHERE id = ?", pollId), user)
+}
+
+// Read a poll row and its results. Results of polls hiding them are left out until the poll closes,
+// only the number of voters and the user's own choices are returned.
+func scanPoll(db *sql.DB, row *sql.Row, user string) (PollFields, bool) {
+	var poll PollFields
+	err := row.Scan(&poll.Id, &poll.TargetType, &poll.TargetId, &poll.GroupId, &poll.Multiple, &poll.ClosesAt, &poll.HideResults)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			fmt.Println("error getting poll", err)
+		}
+		return poll, false
+	}
+	poll.Closed = poll.ClosesAt != 0 && int64(poll.ClosesAt) <= time.Now().UnixMilli()
+	poll.ResultsHidden = poll.HideResults && !poll.Closed
+	poll.Options = []PollOptionFields{}
+	poll.Voted = []string{}
+	rows, err := db.Query(`SELECT o.id, o.text, COUNT(v.user) FROM pollOptions o
+		LEFT JOIN pollVotes v ON v.pollId = o.pollId AND v.optionId = o.id
+		WHERE o.pollId = ? GROUP BY o.id ORDER BY o.position`, poll.Id)
+	if err != nil {
+		fmt.Println("error getting poll options", err)
+		return poll, true
+	}
+	for rows.Next() {
+		var option PollOptionFields
+		rows.Scan(&option.Id, &option.Text, &option.Votes)
+		if poll.ResultsHidden {
+			option.Votes = 0
+		}
+		poll.Options = append(poll.Options, option)
+	}
+	rows.Close()
+	db.QueryRow("SELECT COUNT(*) FROM pollBallots WHERE pollId = ?", poll.Id).Scan(&poll.Voters)
+	if user != "" {
+		rows, err = db.Query("SELECT optionId FROM pollVotes WHERE pollId = ? AND user = ?", poll.Id, user)
+		if err != nil {
+			fmt.Println("error getting poll votes", err)
+			return poll, true
+		}
+		for rows.Next() {
+			var optionId string
+			rows.Scan(&optionId)
+			poll.Voted = append(poll.Voted, optionId)
+		}
+		rows.Close()
+	}
+	return poll, true
+}
+
+// Cast user's ballot. Each user votes once per poll, for one option or, on multiple choice
+// polls, for any number of them.
+func Vote(pollId, user string, optionIds []string) error {
+	poll, ok := GetPoll(pollId, user)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if poll.Closed {
+		return errPollClosed
+	}
+	if len(optionIds) == 0 || (!poll.Multiple && len(optionIds) > 1) {
+		return errInvalidBallot
+	}
+	var pollOptions []string
+	for _, option := range poll.Options {
+		pollOptions = append(pollOptions, option.Id)
+	}
+	for i, optionId := range optionIds {
+		if !Contains(pollOptions, optionId) || Contains(optionIds[:i], optionId) {
+			return errInvalidBallot
+		}
+	}
+
+	db := OpenDB()
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		fmt.Println("error voting", err)
+		return err
+	}
+	now := time.Now().UnixMilli()
+	// The ballot is the user's single entry for the poll, a second one is ignored.
+	result, err := tx.Exec("INSERT OR IGNORE INTO pollBallots (pollId, user, time) VALUES (?, ?, ?)", pollId, user, now)
+	if err == nil {
+		if added, _ := result.RowsAffected(); added == 0 {
+			tx.Rollback()
+			return errAlreadyVoted
+		}
+	}
+	for _, optionId := range optionIds {
+		if err != nil {
+			break
+		}
+		_, err = tx.Exec("INSERT INTO pollVotes (pollId, optionId, user, time) VALUES (?, ?, ?, ?)", pollId, optionId, user, now)
+	}
+	if err != nil {
+		fmt.Println("error voting", err)
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Push the current results of a poll to everyone looking at it: the group room for
+// group posts and the online users who can see the post otherwise.
+func BroadcastPoll(pollId string) {
+	poll, ok := GetPoll(pollId, "")
+	if !ok {
+		return
+	}
+	if poll.TargetType == "group-post" {
+		H.broadcast <- message{incomingData: poll}
+		return
+	}
+	var author string
+	db := OpenDB()
+	db.QueryRow("SELECT author FROM posts WHERE id = ?", poll.TargetId).Scan(&author)
+	db.Close()
+	// Only the online users who can see the post get the results, worked out here
+	// rather than on the hub's goroutine as each check may query the database.
+	post := GetPost(poll.TargetId, author)
+	var receivers []string
+	for _, name := range H.OnlineUsers() {
+		if CanView(name, post) {
+			receivers = append(receivers, name)
+		}
+	}
+	H.SendTo(receivers, poll)
+}
+
+// Push the results of polls that closed since the last run, revealing hidden results.
+func AnnounceClosedPolls() {
+	db := OpenDB()
+	rows, err := db.Query("SELECT id FROM polls WHERE announced = 0 AND closesAt != 0 AND closesAt <= ?", time.Now().UnixMilli())
+	if err != nil {
+		fmt.Println("error getting closed polls", err)
+		db.Close()
+		return
+	}
+	var closed []string
+	for rows.Next() {
+		var pollId string
+		rows.Scan(&pollId)
+		closed = append(closed, pollId)
+	}
+	rows.Close()
+	for _, pollId := range closed {
+		db.Exec("UPDATE polls SET announced = 1 WHERE id = ?", pollId)
+	}
+	db.Close()
+	for _, pollId := range closed {
+		BroadcastPoll(pollId)
+	}
+}
+
+func RemovePoll(targetType, targetId string) {
+	db := OpenDB()
+	defer db.Close()
+	var pollId string
+	db.QueryRow("SELECT id FROM polls WHERE targetType = ? AND targetId = ?", targetType, targetId).Scan(&pollId)
+	if pollId == "" {
+		return
+	}
+	for _, table := range []string{"pollVotes", "pollBallots", "pollOptions"} {
+		db.Exec("DELETE FROM "+table+" WHERE pollId = ?", pollId)
+	}
+	_, err := db.Exec("DELETE FROM polls WHERE id = ?", pollId)
+	if err != nil {
+		fmt.Println("error removing poll", err)
+	}
+}
+
+//
+// Stories
+//
+
+// How long a story is shown for and the longest text it can carry.
+var StoryLifetime = 24 * time.Hour
+
+const maxStoryText = 500
+
+const storyColumns = "s.id, s.author, IFNULL(u.avatar, ''), IFNULL(s.image, ''), IFNULL(s.text, ''), s.created, s.expires"
+
+// Check a new story, "" if it can be added.
+func StoryError(story StoryFields) string {
+	if story.Image == "" && strings.TrimSpace(story.Text) == "" {
+		return "please add an image or text to your story"
+	}
+	if len([]rune(story.Text)) > maxStoryText {
+		return fmt.Sprintf("stories can have up to %v characters", maxStoryText)
+	}
+	// Removing a story removes its image once unused, so it must not point at someone else's upload.
+	if story.Image != "" && !OwnsImage(story.Image, story.Author) {
+		return "please upload the image of your story"
+	}
+	return ""
+}
+
+func AddStory(story StoryFields) (StoryFields, error) {
+	story.Id = Generate()
+	story.Image = StoreDataUrl(story.Image, story.Author)
+	story.Created = int(time.Now().UnixMilli())
+	story.Expires = story.Created + int(StoryLifetime.Milliseconds())
+	db := OpenDB()
+	defer db.Close()
+	_, err := db.Exec("INSERT INTO stories (id, author, image, text, created, expires) VALUES (?, ?, ?, ?, ?, ?)",
+		story.Id, story.Author, story.Image, story.Text, story.Created, story.Expires)
+	if err != nil {
+		fmt.Println("error adding story", err)
+	}
+	return story, err
+}
+
+// Get a story that has not expired with whether viewer has seen it, and for its author how
+// many people have. Stories viewer may not see are returned empty.
+func GetStory(id, viewer string) StoryFields {
+	db := OpenDB()
+	defer db.Close()
+	var story StoryFields
+	err := db.QueryRow("SELECT "+storyColumns+" FROM stories s LEFT JOIN users u ON u.nickname = s.author WHERE s.id = ? AND s.expires > ?", id, time.Now().UnixMilli()).
+		Scan(&story.Id, &story.Author, &story.AuthorImg, &story.Image, &story.Text, &story.Created, &story.Expires)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			fmt.Println("error getting story", err)
+		}
+		return StoryFields{}
+	}
+	if !CanView(viewer, story) {
+		return StoryFields{}
+	}
+	var seen int
+	db.QueryRow("SELECT COUNT(*) FROM storyViews WHERE storyId = ? AND viewer = ?", id, viewer).Scan(&seen)
+	story.Seen = seen > 0
+	if story.Author == viewer {
+		db.QueryRow("SELECT COUNT(*) FROM storyViews WHERE storyId = ?", id).Scan(&story.Views)
+	}
+	return story
+}
+
+// Get the stories viewer can see that have not expired, grouped by author, oldest story first.
+// Authors with stories the viewer has not seen come first, then those who posted most recently.
+func GetStories(viewer User) []StoryGroupFields {
+	groups := []StoryGroupFields{}
+	db := OpenDB()
+	rows, err := db.Query(`SELECT `+storyColumns+`, EXISTS (SELECT 1 FROM storyViews v WHERE v.storyId = s.id AND v.viewer = ?),
+		(SELECT COUNT(*) FROM storyViews v WHERE v.storyId = s.id)
+		FROM stories s JOIN users u ON u.nickname = s.author
+		WHERE s.expires > ? AND (s.author = ? OR IFNULL(u.status, '') != 'private' OR s.author IN
+			(SELECT fu.nickname FROM followers f JOIN users fu ON fu.email = f.followee WHERE f.follower = ?))
+		ORDER BY s.created, s.id`, viewer.Nickname, time.Now().UnixMilli(), viewer.Nickname, viewer.Email)
+	if err != nil {
+		fmt.Println("error getting stories", err)
+		db.Close()
+		return groups
+	}
+	var stories []StoryFields
+	for rows.Next() {
+		var story StoryFields
+		rows.Scan(&story.Id, &story.Author, &story.AuthorImg, &story.Image, &story.Text, &story.Created, &story.Expires, &story.Seen, &story.Views)
+		if story.Author != viewer.Nickname {
+			story.Views = 0
+		}
+		stories = append(stories, story)
+	}
+	rows.Close()
+	db.Close()
+
+	index := map[string]int{}
+	for _, story := range stories {
+		if story.Author != viewer.Nickname && IsMutedContent(viewer.Nickname, story.Author, story.Text, "") {
+			continue
+		}
+		i, ok := index[story.Author]
+		if !ok {
+			i = len(groups)
+			index[story.Author] = i
+			groups = append(groups, StoryGroupFields{Author: story.Author, AuthorImg: story.AuthorImg})
+		}
+		groups[i].Stories = append(groups[i].Stories, story)
+		if !story.Seen && story.Author != viewer.Nickname {
+			groups[i].Unseen++
+		}
+	}
+	sort.SliceStable(groups, func(a, b int) bool {
+		if (groups[a].Unseen > 0) != (groups[b].Unseen > 0) {
+			return groups[a].Unseen > 0
+		}
+		return groups[a].Stories[len(groups[a].Stories)-1].Created > groups[b].Stories[len(groups[b].Stories)-1].Created
+	})
+	return groups
+}
+
+// Record that viewer has seen a story. Authors looking at their own stories are not recorded.
+func MarkStorySeen(story StoryFields, viewer string) error {
+	if story.Author == viewer {
+		return nil
+	}
+	db := OpenDB()
+	defer db.Close()
+	_, err := db.Exec("INSERT OR IGNORE INTO storyViews (storyId, viewer, time) VALUES (?, ?, ?)", story.Id, viewer, time.Now().UnixMilli())
+	if err != nil {
+		fmt.Println("error marking story seen", err)
+	}
+	return err
+}
+
+// Get who has seen a story, most recent first.
+func GetStoryViewers(storyId string) []StoryViewFields {
+	viewers := []StoryViewFields{}
+	db := OpenDB()
+	defer db.Close()
+	rows, err := db.Query(`SELECT v.viewer, IFNULL(u.avatar, ''), v.time FROM storyViews v LEFT JOIN users u ON u.nickname = v.viewer
+		WHERE v.storyId = ? ORDER BY v.time DESC`, storyId)
+	if err != nil {
+		fmt.Println("error getting story viewers", err)
+		return viewers
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var view StoryViewFields
+		rows.Scan(&view.Viewer, &view.ViewerImg, &view.Time)
+		viewers = append(viewers, view)
+	}
+	return viewers
+}
+
+// Remove a story with its views, and its image unless something else uses it.
+func RemoveStory(id string) error {
+	db := OpenDB()
+	var image string
+	db.QueryRow("SELECT IFNULL(image, '') FROM stories WHERE id = ?", id).Scan(&image)
+	db.Exec("DELETE FROM storyViews WHERE storyId = ?", id)
+	_, err := db.Exec("DELETE FROM stories WHERE id = ?", id)
+	db.Close()
+	if err != nil {
+		fmt.Println("error removing story", err)
+		return err
+	}
+	RemoveUnusedMedia(image)
+	return nil
+}
+
+// Remove the stories that expired. Runs with the scheduler.
+func PurgeExpiredStories() {
+	db := OpenDB()
+	rows, err := db.Query("SELECT id FROM stories WHERE expires <= ?", time.Now().UnixMilli())
+	if err != nil {
+		fmt.Println("error getting expired stories", err)
+		db.Close()
+		return
+	}
+	var expired []string
+	for rows.Next() {
+		var id string
+		rows.Scan(&id)
+		expired = append(expired, id)
+	}
+	rows.Close()
+	db.Close()
+	for _, id := range expired {
+		RemoveStory(id)
+	}
+}
+
+//
+// Comments
+//
+
+const maxCommentText = 2000
+
+// Validate the content of a new or edited comment, "" when it is valid.
+func CommentError(comment CommentFields) string {
+	if strings.TrimSpace(comment.Text) == "" && comment.Image == "" && len(comment.Thread) == 0 {
+		return "Cannot submit empty comment"
+	}
+	if len([]rune(comment.Text)) > maxCommentText {
+		return fmt.Sprintf("comments can have up to %v characters", maxCommentText)
+	}
+	return ""
+}
+
+func AddComment(commentFields CommentFields) error {
+	commentFields.Image = StoreDataUrl(commentFields.Image, commentFields.Author)
+	commentFields.Thread = NormaliseThread(commentFields.Thread)
+	fmt.Println("comments", commentFields)
+	db := OpenDB()
+	defer db.Close()
+	// A reply is stored with its place in the thread or not at all.
+	tx, err := db.Begin()
+	if err != nil {
+		fmt.Println("error adding comment", err)
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO "comments" (id, postid, author, image, text, thread, time) values(?, ?, ?, ?, ?, ?, ?)`,
+		commentFields.CommentId, commentFields.PostId, commentFields.Author, commentFields.Image, commentFields.Text, commentFields.Thread, commentFields.Time)
+	if err != nil {
+		fmt.Println("Error adding comment to comments table", err)
+		tx.Rollback()
+		return err
+	}
+	var parentAuthor string
+	if commentFields.ParentId != "" {
+		parentAuthor, err = SaveReply(tx, "comment", commentFields.CommentId, commentFields.ParentId)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		fmt.Println("error adding comment", err)
+		return err
+	}
+	fmt.Println("added comment to comments table")
+	SaveTags("comment", commentFields.CommentId, commentFields.Thread, commentFields.Time)
+	post := GetPost(commentFields.PostId, commentFields.Author)
+	SaveMentions("comment", commentFields.CommentId, commentFields.PostId, commentFields.Author, commentFields.Text, commentFields.Time, func(user string) bool {
+		return CanView(user, post)
+	})
+	if commentFields.ParentId != "" {
+		NotifyReply("comment", commentFields, post.Author, parentAuthor, func(user string) bool {
+			return CanView(user, post)
+		})
+	}
+	return nil
+}
+
+func UpdateComment(commentFields CommentFields) error {
+	commentFields.Image = StoreDataUrl(commentFields.Image, commentFields.Author)
+	commentFields.Thread = NormaliseThread(commentFields.Thread)
+	current := GetComment(commentFields.CommentId, commentFields.Author)
+	if err := SaveRevision(RevisionFields{Type: "comment", Id: current.CommentId, Author: current.Author, Image: current.Image, Text: current.Text, Thread: current.Thread, Time: current.Time},
+		RevisionFields{Image: commentFields.Image, Text: commentFields.Text, Thread: commentFields.Thread}); err != nil {
+		return err
+	}
+	db := OpenDB()
+	defer db.Close()
+	_, err := db.Exec(`UPDATE "comments" SET "text" = ?, "thread" = ?, "image" = ? WHERE "id" = ?`, commentFields.Text, commentFields.Thread, commentFields.Image, commentFields.CommentId)
+	if err != nil {
+		fmt.Println("Cannot update comment", err)
+		return err
+	}
+	SaveTags("comment", current.CommentId, commentFields.Thread, 0)
+	post := GetPost(current.PostId, current.Author)
+	SaveMentions("comment", current.CommentId, current.PostId, current.Author, commentFields.Text, 0, func(user string) bool {
+		return CanView(user, post)
+	})
+	return nil
+}
+
+func RemoveComment(id string) error {
+	db := OpenDB()
+	defer db.Close()
+	stmt, err := db.Prepare(`DELETE FROM "comments" WHERE "id" = ?`)
+	if err != nil {
+		fmt.Println("error removing post from posts table", err)
+	}
+	stmt.Exec(id)
+	RemoveTags("comment", id)
+	RemoveMentions("comment", id)
+	RemoveRevisions("comment", id)
+	RemoveReactions("comment", id)
+	RemoveReplies("comment", id)
+	return err
+}
+
+func GetComment(commentId, user string) CommentFields {
+	comment := getComment(commentId, user)
+	if comment.CommentId != "" {
+		LoadCommentReactions(&comment, "comment", user)
+	}
+	return comment
+}
+
+// Like GetComment, without reactions for listings that load them for the whole page.
+func getComment(commentId, user string) CommentFields {
+	db := OpenDB()
+	defer db.Close()
+	s := fmt.Sprintf("SELECT * FROM comments WHERE id = '%v'", commentId)
+	rows, _ := db.Query(s)
+	var commentid, postid, author, image, thread, text string
+	var time int
+	var commentPost CommentFields
+
+	for rows.Next() {
+		rows.Scan(&commentid, &postid, &author, &image, &text, &thread, &time)
+		commentPost = CommentFields{
+			CommentId: commentid,
+			PostId:    postid,
+			Author:    author,
+			Image:     image,
+			Text:      text,
+			Thread:    thread,
+			Time:      time,
+		}
+		row, err := PreparedQuery("SELECT * FROM users WHERE nickname = ?", commentPost.Author, db, "GetUserFromPosts")
+		commentPost.AuthorImg = QueryUser(row, err).Avatar
+		commentPost.Mentions = ResolveMentions(commentPost.Text)
+		commentPost.EditedAt = GetLastEdit("comment", commentPost.CommentId)
+		commentPost.Edited = commentPost.EditedAt != 0
+		if commentPost.Author == user {
+			commentPost.CommentAuthor = true
+		}
+		LoadReplyFields(&commentPost, "comment")
+		commentPost.CanEdit = CanEditComment(user, commentPost)
+		commentPost.CanDelete = CanDeleteComment(user, "comment", commentPost)
+	}
+	rows.Close()
+	return commentPost
+}
+
+//
+// Comment Replies
+//
+
+// Deepest a reply can be nested, top level comments are depth 0. Replying deeper than this
+// attaches the reply to the parent's parent, so the thread stays at the limit.
+const MaxReplyDepth = 3
+
+// Replies loaded with each comment of a tree, the rest are loaded a page at a time.
+const InlineReplies = 3
+
+var errUnknownParent = errors.New("unknown parent comment")
+
+func commentTable(targetType string) string {
+	if targetType == "group-comment" {
+		return "groupComments"
+	}
+	return "comments"
+}
+
+func getTypedComment(targetType, commentId, user string) CommentFields {
+	if targetType == "group-comment" {
+		return GetGroupPostComment(commentId, user)
+	}
+	return GetComment(commentId, user)
+}
+
+func getTypedCommentFields(targetType, commentId, user string) CommentFields {
+	if targetType == "group-comment" {
+		return getGroupPostComment(commentId, user)
+	}
+	return getComment(commentId, user)
+}
+
+// Load the reactions of a page of comments and of the replies nested in them in one query.
+func loadPageReactions(comments []CommentFields, targetType, user string) {
+	var all []*CommentFields
+	var collect func(comments []CommentFields)
+	collect = func(comments []CommentFields) {
+		for i := range comments {
+			all = append(all, &comments[i])
+			collect(comments[i].Replies)
+		}
+	}
+	collect(comments)
+	LoadCommentListReactions(all, targetType, user)
+}
+
+func updateTypedComment(targetType string, commentFields CommentFields) error {
+	if targetType == "group-comment" {
+		return UpdateGroupPostComment(commentFields)
+	}
+	return UpdateComment(commentFields)
+}
+
+// Link a new comment to the comment it replies to and return the author of that comment.
+// Runs in the transaction adding the comment, so a reply to an unknown parent is not kept.
+func SaveReply(tx *sql.Tx, targetType, commentId, parentId string) (string, error) {
+	var parentAuthor string
+	var depth int
+	err := tx.QueryRow(`SELECT c.author, IFNULL(r.depth, 0) FROM `+commentTable(targetType)+` c
+		LEFT JOIN commentReplies r ON r.targetType = ? AND r.commentId = c.id WHERE c.id = ?`, targetType, parentId).Scan(&parentAuthor, &depth)
+	if err != nil {
+		return "", errUnknownParent
+	}
+	if depth >= MaxReplyDepth {
+		tx.QueryRow("SELECT parentId FROM commentReplies WHERE targetType = ? AND commentId = ?", targetType, parentId).Scan(&parentId)
+		depth = MaxReplyDepth - 1
+	}
+	_, err = tx.Exec("INSERT INTO commentReplies (targetType, commentId, parentId, depth) VALUES (?, ?, ?, ?)", targetType, commentId, parentId, depth+1)
+	if err != nil {
+		fmt.Println("error adding reply", err)
+		return "", err
+	}
+	return parentAuthor, nil
+}
+
+// Fill in where a comment sits in its thread and how many replies it has.
+func LoadReplyFields(comment *CommentFields, targetType string) {
+	db := OpenDB()
+	defer db.Close()
+	db.QueryRow("SELECT parentId, depth FROM commentReplies WHERE targetType = ? AND commentId = ?", targetType, comment.CommentId).Scan(&comment.ParentId, &comment.Depth)
+	db.QueryRow("SELECT COUNT(*) FROM commentReplies WHERE targetType = ? AND parentId = ?", targetType, comment.CommentId).Scan(&comment.ReplyCount)
+}
+
+// Orders top level comments can be listed in. Replies are always oldest first.
+var CommentSorts = []string{"oldest", "newest", "most-reacted"}
+
+// Top level comments in a page when the client does not ask for a size.
+const CommentPageSize = 20
+
+// Get a page of the top level comments of a post or group post, each with its first replies
+// nested down to MaxReplyDepth. Replies past those have a replies cursor. Comments are sorted
+// oldest or newest first, or by their number of reactions with ties oldest first.
+func GetComments(targetType, postId, user, sort, cursor string, limit int) (CommentPage, error) {
+	page := CommentPage{Comments: []CommentFields{}}
+	key, err := DecodeCursor(cursor)
+	if err != nil {
+		return page, err
+	}
+	var after []interface{}
+	if len(key) == 3 {
+		for _, value := range key[:2] {
+			number, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return page, err
+			}
+			after = append(after, number)
+		}
+		after = append(after, key[2])
+	}
+
+	order, condition := "time, id", "(time > ? OR (time = ? AND id > ?))"
+	args := []interface{}{targetType, postId, targetType}
+	switch sort {
+	case "newest":
+		order, condition = "time DESC, id DESC", "(time < ? OR (time = ? AND id < ?))"
+	case "most-reacted":
+		order, condition = "score DESC, time, id", "(score < ? OR (score = ? AND (time > ? OR (time = ? AND id > ?))))"
+	}
+	if after == nil {
+		condition = "1"
+	} else if sort == "most-reacted" {
+		args = append(args, after[0], after[0], after[1], after[1], after[2])
+	} else {
+		args = append(args, after[1], after[1], after[2])
+	}
+	args = append(args, limit+1)
+
+	db := OpenDB()
+	rows, err := db.Query(`SELECT id, time, score FROM (SELECT c.id, c.time,
+			(SELECT COUNT(*) FROM reactions x WHERE x.targetType = ? AND x.targetId = c.id) AS score
+			FROM `+commentTable(targetType)+` c WHERE c.postid = ?
+			AND NOT EXISTS (SELECT 1 FROM commentReplies r WHERE r.targetType = ? AND r.commentId = c.id))
+		WHERE `+condition+` ORDER BY `+order+` LIMIT ?`, args...)
+	if err != nil {
+		fmt.Println("error getting comments", err)
+		db.Close()
+		return page, err
+	}
+	var keys [][]string
+	var id string
+	var commentTime, score int64
+	for rows.Next() {
+		rows.Scan(&id, &commentTime, &score)
+		keys = append(keys, []string{strconv.FormatInt(score, 10), strconv.FormatInt(commentTime, 10), id})
+	}
+	rows.Close()
+	db.Close()
+
+	for i, key := range keys {
+		if i == limit {
+			page.NextCursor = EncodeCursor(keys[i-1]...)
+			break
+		}
+		comment := getTypedCommentFields(targetType, key[2], user)
+		if comment.CommentId == "" {
+			continue
+		}
+		loadInlineReplies(&comment, targetType, user)
+		page.Comments = append(page.Comments, comment)
+	}
+	loadPageReactions(page.Comments, targetType, user)
+	return page, nil
+}
+
+// Number of comments and replies on a post or group post, counted on the postid index
+// rather than by loading the comments.
+func CommentCount(targetType, postId string) int {
+	db := OpenDB()
+	defer db.Close()
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM "+commentTable(targetType)+" WHERE postid = ?", postId).Scan(&count)
+	if err != nil {
+		fmt.Println("error counting comments", err)
+	}
+	return count
+}
+
+func loadInlineReplies(comment *CommentFields, targetType, user string) {
+	if comment.ReplyCount == 0 {
+		return
+	}
+	page, err := commentReplies(targetType, comment.CommentId, user, "", InlineReplies)
+	if err != nil {
+		return
+	}
+	comment.Replies = page.Comments
+	comment.RepliesCursor = page.NextCursor
+}
+
+// Get a page of the replies to a comment, oldest first, each with its own first replies.
+func GetCommentReplies(targetType, parentId, user, cursor string, limit int) (CommentPage, error) {
+	page, err := commentReplies(targetType, parentId, user, cursor, limit)
+	loadPageReactions(page.Comments, targetType, user)
+	return page, err
+}
+
+// GetCommentReplies without reactions, which are loaded once for the whole page.
+func commentReplies(targetType, parentId, user, cursor string, limit int) (CommentPage, error) {
+	page := CommentPage{Comments: []CommentFields{}}
+	afterTime, afterId := int64(-1), ""
+	if key, err := DecodeCursor(cursor); err != nil {
+		return page, err
+	} else if len(key) == 2 {
+		if afterTime, err = strconv.ParseInt(key[0], 10, 64); err != nil {
+			return page, err
+		}
+		afterId = key[1]
+	}
+
+	db := OpenDB()
+	rows, err := db.Query(`SELECT c.id, c.time FROM commentReplies r JOIN `+commentTable(targetType)+` c ON c.id = r.commentId
+		WHERE r.targetType = ? AND r.parentId = ? AND (c.time > ? OR (c.time = ? AND c.id > ?))
+		ORDER BY c.time, c.id LIMIT ?`, targetType, parentId, afterTime, afterTime, afterId, limit+1)
+	if err != nil {
+		fmt.Println("error getting replies", err)
+		db.Close()
+		return page, err
+	}
+	var keys [][]string
+	var id string
+	var replyTime int64
+	for rows.Next() {
+		rows.Scan(&id, &replyTime)
+		keys = append(keys, []string{strconv.FormatInt(replyTime, 10), id})
+	}
+	rows.Close()
+	db.Close()
+
+	for i, key := range keys {
+		if i == limit {
+			page.NextCursor = EncodeCursor(keys[i-1]...)
+			break
+		}
+		reply := getTypedCommentFields(targetType, key[1], user)
+		if reply.CommentId == "" {
+			continue
+		}
+		loadInlineReplies(&reply, targetType, user)
+		page.Comments = append(page.Comments, reply)
+	}
+	return page, nil
+}
+
+// Notify the post author and the author of the comment replied to about a new reply.
+// Users who cannot see the post or who muted the replier are not notified.
+func NotifyReply(targetType string, reply CommentFields, postAuthor, parentAuthor string, canSee func(user string) bool) {
+	var notifications []ReplyNotification
+	db := OpenDB()
+	for _, user := range []string{parentAuthor, postAuthor} {
+		if user == "" || user == reply.Author || !canSee(user) {
+			continue
+		}
+		_, err := db.Exec("INSERT OR IGNORE INTO replyNotifications (targetType, commentId, postId, author, receiver, time, seen) VALUES (?, ?, ?, ?, ?, ?, 0)",
+			targetType, reply.CommentId, reply.PostId, reply.Author, user, reply.Time)
+		if err != nil {
+			fmt.Println("error adding reply notification", err)
+			continue
+		}
+		notifications = append(notifications, ReplyNotification{
+			Type:     targetType,
+			Id:       reply.CommentId,
+			PostId:   reply.PostId,
+			Author:   reply.Author,
+			Receiver: user,
+			Time:     reply.Time,
+		})
+	}
+	db.Close()
+
+	for _, notification := range notifications {
+		if IsMutedContent(notification.Receiver, reply.Author, "", "") {
+			continue
+		}
+		H.SendTo([]string{notification.Receiver}, notification)
+	}
+}
+
+// Get the reply notifications of a user, newest first. Replies by muted users are hidden but kept in the table.
+func GetReplyNotifications(user string, limit int) []ReplyNotification {
+	db := OpenDB()
+	defer db.Close()
+	sliceOfReplies := []ReplyNotification{}
+	rows, err := db.Query(`SELECT targetType, commentId, postId, author, time, seen FROM replyNotifications
+		WHERE receiver = ? AND author NOT IN (SELECT muted FROM mutes WHERE user = ? AND type = 'user' AND (expires = 0 OR expires > ?))
+		ORDER BY time DESC LIMIT ?`, user, user, time.Now().UnixMilli(), limit)
+	if err != nil {
+		fmt.Println("error getting reply notifications", err)
+		return sliceOfReplies
+	}
+	for rows.Next() {
+		reply := ReplyNotification{Receiver: user}
+		rows.Scan(&reply.Type, &reply.Id, &reply.PostId, &reply.Author, &reply.Time, &reply.Seen)
+		sliceOfReplies = append(sliceOfReplies, reply)
+	}
+	rows.Close()
+	return sliceOfReplies
+}
+
+// Mark reply notifications as seen. An empty comment id marks all of the user's replies.
+func MarkRepliesSeen(user, targetType, commentId string) error {
+	db := OpenDB()
+	defer db.Close()
+	var err error
+	if commentId == "" {
+		_, err = db.Exec("UPDATE replyNotifications SET seen = 1 WHERE receiver = ?", user)
+	} else {
+		_, err = db.Exec("UPDATE replyNotifications SET seen = 1 WHERE receiver = ? AND targetType = ? AND commentId = ?", user, targetType, commentId)
+	}
+	if err != nil {
+		fmt.Println("error updating reply notifications", err)
+	}
+	return err
+}
+
+// Called when a comment is removed: its replies are removed with it.
+func RemoveReplies(targetType, commentId string) {
+	db := OpenDB()
+	rows, err := db.Query("SELECT commentId FROM commentReplies WHERE targetType = ? AND parentId = ?", targetType, commentId)
+	if err != nil {
+		fmt.Println("error getting replies", err)
+		db.Close()
+		return
+	}
+	var replies []string
+	var id string
+	for rows.Next() {
+		rows.Scan(&id)
+		replies = append(replies, id)
+	}
+	rows.Close()
+	db.Exec("DELETE FROM commentReplies WHERE targetType = ? AND commentId = ?", targetType, commentId)
+	db.Exec("DELETE FROM replyNotifications WHERE targetType = ? AND commentId = ?", targetType, commentId)
+	db.Close()
+
+	for _, reply := range replies {
+		if targetType == "group-comment" {
+			RemoveGroupPostComment(reply)
+		} else {
+			RemoveComment(reply)
+		}
+	}
+}
+
+// Followers
+
+func GetUserFromFollowMessage(email string) User {
+	db := OpenDB()
+	defer db.Close()
+	//get the users who have interacted
+	row, err := PreparedQuery("SELECT * FROM users WHERE email = ?", email, db, "GetUserFromFollowers")
+	return QueryUser(row, err)
+}
+
+func updateFollowerCount(followerEmail string, followeeEmail string, isFollowing bool) (int, int, error) {
+	// Update the follower count in the database.
+
+	db := OpenDB()
+	defer db.Close()
+
+	// Create a map representing the follow object to update the db.
+	follow := make(map[string]string)
+	follow["follower"] = followerEmail
+	follow["followee"] = followeeEmail
+
+	// Increment if follow button pressed otherwise decrement.
+	if isFollowing {
+		db.Exec("UPDATE users SET followers=followers+1 WHERE email=?", followeeEmail)
+		db.Exec("UPDATE users SET following=following+1 WHERE email=?", followerEmail)
+		PreparedExec("INSERT INTO followers (follower, followee) values (?,?)", follow, db, "updateFollowerCount")
+	} else {
+		db.Exec("UPDATE users SET followers=followers-1 WHERE email=?", followeeEmail)
+		db.Exec("UPDATE users SET following=following-1 WHERE email=?", followerEmail)
+		PreparedExec("DELETE FROM followers WHERE follower=? AND followee=?", follow, db, "updateFollowerCount")
+	}
+
+	// Secure sql query and get user based on session, get followee updated following/followers details
+	rows, err := PreparedQuery("SELECT * FROM users WHERE email = ?", followeeEmail, db, "updateFollowerCount")
+	followee := QueryUser(rows, err)
+
+	// get follower updated following/followers details
+	rows1, err1 := PreparedQuery("SELECT * FROM users WHERE email = ?", followerEmail, db, "updateFollowerCount")
+	follower := QueryUser(rows1, err1)
+
+	// Return the new follower count
+	return followee.Followers, follower.Following, nil
+}
+
+// Delete a follow and update both counters in one transaction. Returns the followee's
+// follower count and the follower's following count, or sql.ErrNoRows if there was no follow.
+func RemoveFollower(followerEmail, followeeEmail string) (int, int, error) {
+	db := OpenDB()
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	result, err := tx.Exec("DELETE FROM followers WHERE follower = ? AND followee = ?", followerEmail, followeeEmail)
+	if err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+	if removed, _ := result.RowsAffected(); removed == 0 {
+		tx.Rollback()
+		return 0, 0, sql.ErrNoRows
+	}
+	var followers, following int
+	_, err = tx.Exec("UPDATE users SET followers = MAX(followers - 1, 0) WHERE email = ?", followeeEmail)
+	if err == nil {
+		_, err = tx.Exec("UPDATE users SET following = MAX(following - 1, 0) WHERE email = ?", followerEmail)
+	}
+	if err == nil {
+		err = tx.QueryRow("SELECT followers FROM users WHERE email = ?", followeeEmail).Scan(&followers)
+	}
+	if err == nil {
+		err = tx.QueryRow("SELECT following FROM users WHERE email = ?", followerEmail).Scan(&following)
+	}
+	if err != nil {
+		fmt.Println("error removing follower", err)
+		tx.Rollback()
+		return 0, 0, err
+	}
+	return followers, following, tx.Commit()
+}
+
+func GetFollowers(user User) []string {
+	db := OpenDB()
+	defer db.Close()
+	s := fmt.Sprintf("SELECT * FROM followers WHERE follower = '%v' OR followee = '%v'", user.Email, user.Email)
+	rows, _ := db.Query(s)
+	var id int
+	var follower, followee string
+	var friends []string
+	for rows.Next() {
+		err := rows.Scan(&id, &follower, &followee)
+		if err != nil {
+			fmt.Println("error getting friends", err)
+		} else {
+			if follower == user.Email {
+				row, err := PreparedQuery("SELECT * FROM users WHERE email = ?", followee, db, "GetUserFromFollowers")
+				name := QueryUser(row, err).Nickname
+				if !Contains(friends, name) {
+					friends = append(friends, name)
+				}
+
+			} else {
+				row, err := PreparedQuery("SELECT * FROM users WHERE email = ?", follower, db, "GetUserFromFollowers")
+				name := QueryUser(row, err).Nickname
+				if !Contains(friends, name) {
+					friends = append(friends, name)
+				}
+			}
+		}
+
+	}
+	friends = append(friends, user.Nickname)
+	rows.Close()
+	return friends
+}
+
+func GetFollowing(user User) []string {
+	db := OpenDB()
+	defer db.Close()
+	s := fmt.Sprintf("SELECT * FROM followers WHERE follower = '%v'", user.Email)
+	rows, err := db.Query(s)
+	if err != nil {
+		fmt.Println("error selecting followees", err)
+	}
+	var id int
+	var follower, followee string
+	var friends []string
+	for rows.Next() {
+		err := rows.Scan(&id, &follower, &followee)
+		if err != nil {
+			fmt.Println("error getting friends", err)
+		} else {
+			row, err := PreparedQuery("SELECT * FROM users WHERE email = ?", followee, db, "GetUserFromFollowers")
+			name := QueryUser(row, err).Nickname
+			if !Contains(friends, name) {
+				friends = append(friends, name)
+			}
+		}
+
+	}
+	friends = append(friends, user.Nickname)
+	rows.Close()
+	return friends
+}
+
+func GetTotalFollowers(email string) int {
+	db := OpenDB()
+	defer db.Close()
+	s := fmt.Sprintf("SELECT * FROM followers WHERE follower = '%v'", email)
+	rows, _ := db.Query(s)
+	var id int
+	var follower, followee string
+	var totalFollowers []Follow
+	for rows.Next() {
+		err := rows.Scan(&id, &follower, &followee)
+		if err != nil {
+			fmt.Println("error getting friends", err)
+		}
+		followField := Follow{
+			Follower: follower,
+			Followee: followee,
+		}
+		totalFollowers = append(totalFollowers, followField)
+	}
+	rows.Close()
+
+	return len(totalFollowers)
+}
+
+//
+// User search
+//
+
+// Get the emails the user follows and the emails following the user.
+func GetFollowSets(email string) (map[string]bool, map[string]bool) {
+	db := OpenDB()
+	defer db.Close()
+	following := make(map[string]bool)
+	followers := make(map[string]bool)
+	rows, err := db.Query("SELECT follower, followee FROM followers WHERE follower = ? OR followee = ?", email, email)
+	if err != nil {
+		fmt.Println("error getting follow sets", err)
+		return following, followers
+	}
+	var follower, followee string
+	for rows.Next() {
+		rows.Scan(&follower, &followee)
+		if follower == email {
+			following[followee] = true
+		}
+		if followee == email {
+			followers[follower] = true
+		}
+	}
+	rows.Close()
+	return following, followers
+}
+
+// Build the summary of a user as seen by the viewer. Email is only shared when the viewer
+// is allowed to see the profile: their own account, a public account or one they follow.
+func summariseUser(viewer User, user User, following, followers map[string]bool) UserSummaryFields {
+	summary := UserSummaryFields{
+		Nickname:   user.Nickname,
+		Firstname:  user.Firstname,
+		Lastname:   user.Lastname,
+		Avatar:     user.Avatar,
+		Status:     user.Status,
+		Following:  following[user.Email],
+		FollowsYou: followers[user.Email],
+	}
+	summary.Mutual = summary.Following && summary.FollowsYou
+	if user.Email == viewer.Email || user.Status != "private" || summary.Following {
+		summary.Email = user.Email
+	}
+	return summary
+}
+
+// How well the query matches a name: 0 exact, 1 prefix, 2+ typos within the prefix, -1 no match.
+func nameMatchScore(query, name string) int {
+	name = strings.ToLower(name)
+	if name == "" {
+		return -1
+	}
+	if name == query {
+		return 0
+	}
+	if strings.HasPrefix(name, query) {
+		return 1
+	}
+	// Allow one typo for short queries and two for longer ones.
+	queryRunes := []rune(query)
+	nameRunes := []rune(name)
+	maxTypos := 0
+	if len(queryRunes) >= 6 {
+		maxTypos = 2
+	} else if len(queryRunes) >= 3 {
+		maxTypos = 1
+	}
+	best := -1
+	for length := len(queryRunes) - 1; length <= len(queryRunes)+1; length++ {
+		if length <= 0 || length > len(nameRunes) {
+			continue
+		}
+		distance := editDistance(queryRunes, nameRunes[:length])
+		if distance <= maxTypos && (best == -1 || distance < best) {
+			best = distance
+		}
+	}
+	if best == -1 {
+		return -1
+	}
+	return 2 + best
+}
+
+// Levenshtein distance between two strings.
+func editDistance(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Most users read from each name index per search.
+const maxUserCandidates = 500
+
+// LIKE pattern matching names that start with prefix, with the wildcards in prefix escaped.
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix) + "%"
+}
+
+// Search users by nickname, first and last name. Users the viewer follows come first,
+// mutuals before one way follows, then by match quality and nickname.
+func SearchUsers(viewer User, query, cursor string, limit int) (UserSummaryPage, error) {
+	page := UserSummaryPage{Users: []UserSummaryFields{}}
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return page, nil
+	}
+	after, err := DecodeCursor(cursor)
+	if err != nil {
+		return page, err
+	}
+
+	// Candidates come from the name indexes: names starting with the query (or, for full names,
+	// with its first word), plus a bounded set sharing its first letter for the typo tolerant match.
+	queryRunes := []rune(query)
+	firstWord := strings.Fields(query)[0]
+	db := OpenDB()
+	rows, err := db.Query(`SELECT email, firstname, lastname, avatar, nickname, status FROM (
+			SELECT * FROM (SELECT * FROM users WHERE nickname LIKE ? ESCAPE '\' OR firstname LIKE ? ESCAPE '\' OR lastname LIKE ? ESCAPE '\' LIMIT ?)
+			UNION
+			SELECT * FROM (SELECT * FROM users WHERE nickname LIKE ? ESCAPE '\' OR firstname LIKE ? ESCAPE '\' OR lastname LIKE ? ESCAPE '\' LIMIT ?))
+		WHERE nickname != ?`,
+		likePrefix(query), likePrefix(firstWord), likePrefix(query), maxUserCandidates,
+		likePrefix(string(queryRunes[:1])), likePrefix(string(queryRunes[:1])), likePrefix(string(queryRunes[:1])), maxUserCandidates,
+		viewer.Nickname)
+	if err != nil {
+		db.Close()
+		fmt.Println("error searching users", err)
+		return page, err
+	}
+	following, followers := GetFollowSets(viewer.Email)
+
+	type rankedUser struct {
+		summary UserSummaryFields
+		key     []string
+	}
+	var matches []rankedUser
+	var email, firstname, lastname, avatar, nickname string
+	var status sql.NullString
+	for rows.Next() {
+		rows.Scan(&email, &firstname, &lastname, &avatar, &nickname, &status)
+		score := -1
+		for _, name := range []string{nickname, firstname, lastname, firstname + " " + lastname} {
+			if nameScore := nameMatchScore(query, name); nameScore != -1 && (score == -1 || nameScore < score) {
+				score = nameScore
+			}
+		}
+		if score == -1 {
+			continue
+		}
+		user := User{Email: email, Firstname: firstname, Lastname: lastname, Avatar: avatar, Nickname: nickname, Status: status.String}
+		summary := summariseUser(viewer, user, following, followers)
+		summary.Email = ""
+		relation := 2
+		if summary.Mutual {
+			relation = 0
+		} else if summary.Following {
+			relation = 1
+		}
+		// Zero padded so the keys sort as strings.
+		key := []string{fmt.Sprintf("%d%03d", relation, score), strings.ToLower(nickname), nickname}
+		matches = append(matches, rankedUser{summary: summary, key: key})
+	}
+	rows.Close()
+	db.Close()
+
+	sort.Slice(matches, func(i, j int) bool {
+		return compareCursorKeys(matches[i].key, matches[j].key) < 0
+	})
+	var lastKey []string
+	for _, match := range matches {
+		if after != nil && compareCursorKeys(match.key, after) <= 0 {
+			continue
+		}
+		if len(page.Users) == limit {
+			page.NextCursor = EncodeCursor(lastKey...)
+			break
+		}
+		page.Users = append(page.Users, match.summary)
+		lastKey = match.key
+	}
+	return page, nil
+}
+
+//
+// Presence
+//
+
+func GetLastSeen(user string) int {
+	db := OpenDB()
+	defer db.Close()
+	var lastSeen int
+	err := db.QueryRow("SELECT lastSeen FROM presence WHERE user = ?", user).Scan(&lastSeen)
+	if err != nil && err != sql.ErrNoRows {
+		fmt.Println("error getting last seen", err)
+	}
+	return lastSeen
+}
+
+func SetLastSeen(user string, lastSeen int) {
+	db := OpenDB()
+	defer db.Close()
+	_, err := db.Exec("INSERT INTO presence (user, lastSeen) values (?, ?) ON CONFLICT(user) DO UPDATE SET lastSeen = excluded.lastSeen", user, lastSeen)
+	if err != nil {
+		fmt.Println("error updating last seen", err)
+	}
+}
+
+func GetPresenceSettings(user string) PresenceSettings {
+	db := OpenDB()
+	defer db.Close()
+	var settings PresenceSettings
+	err := db.QueryRow("SELECT hidden FROM presence WHERE user = ?", user).Scan(&settings.Hidden)
+	if err != nil && err != sql.ErrNoRows {
+		fmt.Println("error getting presence settings", err)
+	}
+	return settings
+}
+
+func UpdatePresenceSettings(user string, settings PresenceSettings) error {
+	db := OpenDB()
+	defer db.Close()
+	_, err := db.Exec("INSERT INTO presence (user, hidden) values (?, ?) ON CONFLICT(user) DO UPDATE SET hidden = excluded.hidden", user, settings.Hidden)
+	if err != nil {
+		fmt.Println("error updating presence settings", err)
+	}
+	return err
+}
+
+// Nicknames of everyone sharing a chatroom with the user.
+func GetChatPartners(user string) []string {
+	db := OpenDB()
+	defer db.Close()
+	var partners []string
+	rows, err := db.Query("SELECT users FROM chatroom WHERE instr(',' || users || ',', ',' || ? || ',') > 0", user)
+	if err != nil {
+		fmt.Println("error getting chat partners", err)
+		return partners
+	}
+	var users string
+	for rows.Next() {
+		rows.Scan(&users)
+		for _, member := range strings.Split(users, ",") {
+			if member != "" && member != user && !Contains(partners, member) {
+				partners = append(partners, member)
+			}
+		}
+	}
+	rows.Close()
+	return partners
+}
+
+// Nicknames of the users that receive the user's presence: their followers and chat partners.
+func GetPresenceAudience(user string) []string {
+	audience := GetChatPartners(user)
+	db := OpenDB()
+	defer db.Close()
+	rows, err := db.Query("SELECT follower.nickname FROM followers JOIN users follower ON follower.email = followers.follower JOIN users followee ON followee.email = followers.followee WHERE followee.nickname = ?", user)
+	if err != nil {
+		fmt.Println("error getting presence audience", err)
+		return audience
+	}
+	var nickname string
+	for rows.Next() {
+		rows.Scan(&nickname)
+		if !Contains(audience, nickname) {
+			audience = append(audience, nickname)
+		}
+	}
+	rows.Close()
+	return audience
+}
+
+//
+// Follower lists
+//
+
+// Follower and following lists of a private account are only shown to the
+// account itself and its followers.
+func CanViewFollowLists(viewer, target User) bool {
+	if viewer.Email == target.Email || target.Status != "private" {
+		return true
+	}
+	following, _ := GetFollowSets(viewer.Email)
+	return following[target.Email]
+}
+
+// Page through the followers (list "followers") or followees (list "following") of
+// a user, most recent follows first. Flags are relative to the viewer.
+func GetFollowList(viewer, target User, list, cursor string, limit int) (UserSummaryPage, error) {
+	page := UserSummaryPage{Users: []UserSummaryFields{}}
+	before := math.MaxInt64
+	if key, err := DecodeCursor(cursor); err != nil {
+		return page, err
+	} else if len(key) == 1 {
+		if before, err = strconv.Atoi(key[0]); err != nil {
+			return page, err
+		}
+	}
+	// Join on the other side of the follow from the target.
+	listed, filter := "follower", "followee"
+	if list == "following" {
+		listed, filter = "followee", "follower"
+	}
+
+	db := OpenDB()
+	rows, err := db.Query(`SELECT f.id, u.email, u.firstname, u.lastname, u.avatar, u.nickname, u.status
+		FROM followers f JOIN users u ON u.email = f.`+listed+`
+		WHERE f.`+filter+` = ? AND f.id < ? ORDER BY f.id DESC LIMIT ?`, target.Email, before, limit+1)
+	if err != nil {
+		db.Close()
+		fmt.Println("error getting follow list", err)
+		return page, err
+	}
+	following, followers := GetFollowSets(viewer.Email)
+	var lastId int
+	for rows.Next() {
+		var id int
+		var user User
+		var status sql.NullString
+		rows.Scan(&id, &user.Email, &user.Firstname, &user.Lastname, &user.Avatar, &user.Nickname, &status)
+		if len(page.Users) == limit {
+			page.NextCursor = EncodeCursor(strconv.Itoa(lastId))
+			break
+		}
+		user.Status = status.String
+		summary := summariseUser(viewer, user, following, followers)
+		// Lists identify users by nickname only.
+		summary.Email = ""
+		page.Users = append(page.Users, summary)
+		lastId = id
+	}
+	rows.Close()
+	db.Close()
+	return page, nil
+}
+
+//
+// Follow requests
+//
+
+// A request starts pending and moves once to accepted, declined or cancelled.
+var followRequestTransitions = map[string]string{
+	"accept":  "accepted",
+	"decline": "declined",
+	"cancel":  "cancelled",
+}
+
+func GetUserByNickname(nickname string) User {
+	db := OpenDB()
+	defer db.Close()
+	row, err := PreparedQuery("SELECT * FROM users WHERE nickname = ?", nickname, db, "GetUserByNickname")
+	return QueryUser(row, err)
+}
+
+// Add a pending request unless one is already pending between the two users.
+func AddFollowRequest(requester, target string) (FollowRequestFields, error) {
+	if pending := GetPendingFollowRequest(requester, target); pending.Id != 0 {
+		return pending, nil
+	}
+	db := OpenDB()
+	defer db.Close()
+	now := time.Now().UnixMilli()
+	result, err := db.Exec("INSERT INTO follow_requests (requester, target, state, created, updated) values (?, ?, 'pending', ?, ?)", requester, target, now, now)
+	if err != nil {
+		fmt.Println("error adding follow request", err)
+		return FollowRequestFields{}, err
+	}
+	id, _ := result.LastInsertId()
+	return GetFollowRequest(int(id)), nil
+}
+
+func GetFollowRequest(id int) FollowRequestFields {
+	db := OpenDB()
+	defer db.Close()
+	var request FollowRequestFields
+	err := db.QueryRow("SELECT id, requester, target, state, created, updated FROM follow_requests WHERE id = ?", id).
+		Scan(&request.Id, &request.Requester, &request.Target, &request.State, &request.Created, &request.Updated)
+	if err != nil && err != sql.ErrNoRows {
+		fmt.Println("error getting follow request", err)
+	}
+	return request
+}
+
+func GetPendingFollowRequest(requester, target string) FollowRequestFields {
+	db := OpenDB()
+	defer db.Close()
+	var id int
+	err := db.QueryRow("SELECT id FROM follow_requests WHERE requester = ? AND target = ? AND state = 'pending'", requester, target).Scan(&id)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			fmt.Println("error getting pending follow request", err)
+		}
+		return FollowRequestFields{}
+	}
+	return GetFollowRequest(id)
+}
+
+// List requests sent to (incoming) or by (outgoing) the user in the given state, newest first.
+func GetFollowRequests(user, direction, state string) []FollowRequestFields {
+	db := OpenDB()
+	defer db.Close()
+	column := "target"
+	if direction == "outgoing" {
+		column = "requester"
+	}
+	sliceOfRequests := []FollowRequestFields{}
+	rows, err := db.Query("SELECT id, requester, target, state, created, updated FROM follow_requests WHERE "+column+" = ? AND state = ? ORDER BY created DESC", user, state)
+	if err != nil {
+		fmt.Println("error getting follow requests", err)
+		return sliceOfRequests
+	}
+	for rows.Next() {
+		var request FollowRequestFields
+		rows.Scan(&request.Id, &request.Requester, &request.Target, &request.State, &request.Created, &request.Updated)
+		sliceOfRequests = append(sliceOfRequests, request)
+	}
+	rows.Close()
+	return sliceOfRequests
+}
+
+// Move a pending request to its new state. Returns false if it was no longer pending.
+func UpdateFollowRequestState(id int, state string) bool {
+	db := OpenDB()
+	defer db.Close()
+	result, err := db.Exec("UPDATE follow_requests SET state = ?, updated = ? WHERE id = ? AND state = 'pending'", state, time.Now().UnixMilli(), id)
+	if err != nil {
+		fmt.Println("error updating follow request", err)
+		return false
+	}
+	updated, _ := result.RowsAffected()
+	return updated == 1
+}
+
+// Sent as the action of a follow request notification removed because the request was
+// accepted. Removing it with any other action declines the request.
+const acceptedFollowRequest = "followRequest-accepted"
+
+// Accept a pending request: the follow is added through the hub, which updates
+// both counters and tells everyone online, and the request notification is removed.
+func AcceptFollowRequest(request FollowRequestFields) bool {
+	if !UpdateFollowRequestState(request.Id, "accepted") {
+		return false
+	}
+	SqlExec.RequestNotificationData <- RequestNotifcationFields{Sender: request.Requester, Receiver: request.Target, TypeOfAction: acceptedFollowRequest}
+	requester := GetUserByNickname(request.Requester)
+	target := GetUserByNickname(request.Target)
+	following, _ := GetFollowSets(requester.Email)
+	if !following[target.Email] {
+		H.broadcast <- message{incomingData: followMessage{
+			FollowRequest:         requester.Email,
+			ToFollow:              target.Email,
+			IsFollowing:           true,
+			FollowRequestUsername: requester.Nickname,
+			FolloweeUsername:      target.Nickname,
+			FollowRequestAccepted: true,
+		}}
+	}
+	return true
+}
+
+// Accept every pending request sent to a user, used when a private account goes public.
+func AcceptPendingFollowRequests(user string) {
+	for _, request := range GetFollowRequests(user, "incoming", "pending") {
+		AcceptFollowRequest(request)
+	}
+}
+
+// Copy the follow requests only kept as request notifications, from before follow_requests
+// existed, into follow_requests as pending. Requests already there or already followed are skipped.
+func MigrateFollowRequests() {
 	db := OpenDB()
 	defer db.Close()
-	stmt, err := db.Prepare(`INSERT INTO "comments" (id, postid, author, image, text, thread, time) values(?, ?, ?, ?, ?, ?, ?)`)
+	now := time.Now().UnixMilli()
+	_, err := db.Exec(`INSERT INTO follow_requests (requester, target, state, created, updated)
+		SELECT DISTINCT n.sender, n.receiver, 'pending', ?, ? FROM requestNotification n
+		WHERE n.typeOfRequest = 'followRequest'
+		AND NOT EXISTS (SELECT 1 FROM follow_requests r WHERE r.requester = n.sender AND r.target = n.receiver AND r.state = 'pending')
+		AND NOT EXISTS (SELECT 1 FROM followers f JOIN users a ON a.email = f.follower JOIN users b ON b.email = f.followee
+			WHERE a.nickname = n.sender AND b.nickname = n.receiver)`, now, now)
 	if err != nil {
-		log.Fatal("error preparing to comment table:= ", err)
-		return err
+		fmt.Println("error migrating follow requests", err)
 	}
-	_, err = stmt.Exec(commentFields.CommentId, commentFields.PostId, commentFields.Author, commentFields.Image, commentFields.Text, commentFields.Thread, commentFields.Time)
-	if err != nil {
-		log.Fatal("Error adding comment to table", err)
-		return err
+}
+
+//
+// Suggestions
+//
+
+// Weights for each kind of connection when ranking follow suggestions.
+const (
+	mutualFollowWeight = 3
+	sharedGroupWeight  = 2
+	sharedChatWeight   = 2
+	sharedEventWeight  = 1
+	maxSuggestions     = 50
+)
+
+// How often the cached suggestions are recomputed.
+var SuggestionInterval = 30 * time.Minute
+
+// Recompute suggestions in the background on startup and then every SuggestionInterval.
+func RunSuggestionJob() {
+	for {
+		ComputeSuggestions()
+		time.Sleep(SuggestionInterval)
 	}
-	fmt.Println("added comment to table")
-	return err
 }
 
-func GetPostComments(postId, user string) []CommentFields {
-	// fmt.Println(user, "postId", postId)
+// Rank users that are not followed yet by how connected they are to each user
+// and cache the best suggestions in the suggestions table. Only pairs of users that
+// share a follow, group, chat or event are considered, never every pair of users.
+func ComputeSuggestions() {
 	db := OpenDB()
 	defer db.Close()
-	s := fmt.Sprintf("SELECT * FROM comments WHERE postid = '%v'", postId)
 
-	sliceOfCommentRows := []CommentFields{}
-	rows, _ := db.Query(s)
-	var commentid, postid, author, image, thread, text string
-	var time int
+	// Friends of friends: for each user, the accounts followed by the accounts they follow.
+	// Accounts already followed and pending requests either way are left out.
+	mutualFollows := make(map[[2]string]int)
+	rows, err := db.Query(`SELECT a.nickname, c.nickname, COUNT(*) FROM followers f1
+		JOIN followers f2 ON f2.follower = f1.followee
+		JOIN users a ON a.email = f1.follower
+		JOIN users c ON c.email = f2.followee
+		WHERE f2.followee != f1.follower
+		AND NOT EXISTS (SELECT 1 FROM followers f WHERE f.follower = f1.follower AND f.followee = f2.followee)
+		GROUP BY a.nickname, c.nickname`)
+	if err != nil {
+		fmt.Println("error computing suggestions", err)
+		return
+	}
+	var user, candidate string
+	var count int
+	for rows.Next() {
+		rows.Scan(&user, &candidate, &count)
+		mutualFollows[[2]string{user, candidate}] = count
+	}
+	rows.Close()
 
+	excluded := make(map[[2]string]bool)
+	rows, err = db.Query(`SELECT a.nickname, b.nickname FROM followers f JOIN users a ON a.email = f.follower JOIN users b ON b.email = f.followee
+		UNION ALL SELECT requester, target FROM follow_requests WHERE state = 'pending'
+		UNION ALL SELECT target, requester FROM follow_requests WHERE state = 'pending'`)
+	if err != nil {
+		fmt.Println("error computing suggestions", err)
+		return
+	}
 	for rows.Next() {
-		rows.Scan(&commentid, &postid, &author, &image, &text, &thread, &time)
-		commentRows := CommentFields{
-			CommentId:     commentid,
-			PostId:        postid,
-			Author:        author,
-			Image:         image,
-			Text:          text,
-			Thread:        thread,
-			Time:          time,
-			CommentAuthor: false,
-			Likes:         len(GetCommentLikes(commentid, "l")),
-			Dislikes:      len(GetCommentLikes(commentid, "d")),
-		}
+		rows.Scan(&user, &candidate)
+		excluded[[2]string{user, candidate}] = true
+	}
+	rows.Close()
 
-		row, err := PreparedQuery("SELECT * FROM users WHERE nickname = ?", commentRows.Author, db, "GetUserFromPosts")
-		commentRows.AuthorImg = QueryUser(row, err).Avatar
+	sharedGroups := countSharedMembers(db, "SELECT users, admin FROM groups")
+	sharedChats := countSharedMembers(db, "SELECT users, '' FROM chatroom")
+	sharedEvents := countSharedMembers(db, "SELECT group_concat(user), '' FROM eventAttendance WHERE status = 'y' GROUP BY eventId")
+
+	suggestionsByUser := make(map[string][]SuggestionFields)
+	seen := make(map[[2]string]bool)
+	for _, pairs := range []map[[2]string]int{mutualFollows, sharedGroups, sharedChats, sharedEvents} {
+		for pair := range pairs {
+			if seen[pair] || excluded[pair] || pair[0] == pair[1] || pair[0] == "" || pair[1] == "" {
+				continue
+			}
+			seen[pair] = true
+			suggestion := SuggestionFields{
+				MutualFollows: mutualFollows[pair],
+				SharedGroups:  sharedGroups[pair],
+				SharedChats:   sharedChats[pair],
+				SharedEvents:  sharedEvents[pair],
+			}
+			suggestion.Nickname = pair[1]
+			suggestion.Score = suggestion.MutualFollows*mutualFollowWeight + suggestion.SharedGroups*sharedGroupWeight +
+				suggestion.SharedChats*sharedChatWeight + suggestion.SharedEvents*sharedEventWeight
+			if suggestion.Score > 0 {
+				suggestionsByUser[pair[0]] = append(suggestionsByUser[pair[0]], suggestion)
+			}
+		}
+	}
 
-		if commentRows.Author == user {
-			commentRows.CommentAuthor = true
+	now := time.Now().UnixMilli()
+	tx, err := db.Begin()
+	if err != nil {
+		fmt.Println("error computing suggestions", err)
+		return
+	}
+	tx.Exec("DELETE FROM suggestions")
+	for user, suggestions := range suggestionsByUser {
+		sort.Slice(suggestions, func(i, j int) bool {
+			if suggestions[i].Score != suggestions[j].Score {
+				return suggestions[i].Score > suggestions[j].Score
+			}
+			return suggestions[i].Nickname < suggestions[j].Nickname
+		})
+		if len(suggestions) > maxSuggestions {
+			suggestions = suggestions[:maxSuggestions]
 		}
-		commentLike := GetCommentLike(commentRows.CommentId, user)
-		if commentLike.Like == "l" {
-			commentRows.CommentLiked = true
-		} else if commentLike.Like == "d" {
-			commentRows.CommentDisliked = true
+		for _, suggestion := range suggestions {
+			_, err := tx.Exec("INSERT INTO suggestions (user, suggested, score, mutualFollows, sharedGroups, sharedEvents, sharedChats, computed) values (?, ?, ?, ?, ?, ?, ?, ?)",
+				user, suggestion.Nickname, suggestion.Score, suggestion.MutualFollows, suggestion.SharedGroups, suggestion.SharedEvents, suggestion.SharedChats, now)
+			if err != nil {
+				fmt.Println("error adding suggestion", err)
+			}
 		}
-		sliceOfCommentRows = append(sliceOfCommentRows, commentRows)
 	}
-	rows.Close()
-	return sliceOfCommentRows
+	if err := tx.Commit(); err != nil {
+		fmt.Println("error saving suggestions", err)
+	}
 }
 
-func RemoveComment(id string) error {
-	db := OpenDB()
-	defer db.Close()
-	stmt, err := db.Prepare(`DELETE FROM "comments" WHERE "id" = ?`)
+// Count, for every pair of users, how many member lists (comma separated, plus an
+// optional extra member) they both appear in.
+func countSharedMembers(db *sql.DB, query string) map[[2]string]int {
+	shared := make(map[[2]string]int)
+	rows, err := db.Query(query)
 	if err != nil {
-		fmt.Println("error removing post from posts table", err)
+		fmt.Println("error counting shared members", err)
+		return shared
 	}
-	stmt.Exec(id)
-	return err
+	var users, extra string
+	for rows.Next() {
+		rows.Scan(&users, &extra)
+		var members []string
+		for _, member := range append(strings.Split(users, ","), extra) {
+			if member != "" && !Contains(members, member) {
+				members = append(members, member)
+			}
+		}
+		for _, a := range members {
+			for _, b := range members {
+				if a != b {
+					shared[[2]string{a, b}]++
+				}
+			}
+		}
+	}
+	rows.Close()
+	return shared
 }
 
-func GetComment(commentId, user string) CommentFields {
+// Get the cached suggestions of a user. Users followed, muted or requested since
+// the last computation are filtered out here.
+func GetSuggestions(viewer User, limit int) []SuggestionFields {
 	db := OpenDB()
 	defer db.Close()
-	s := fmt.Sprintf("SELECT * FROM comments WHERE id = '%v'", commentId)
-	rows, _ := db.Query(s)
-	var commentid, postid, author, image, thread, text string
-	var time int
-	var commentPost CommentFields
-
+	sliceOfSuggestions := []SuggestionFields{}
+	rows, err := db.Query(`SELECT u.email, u.firstname, u.lastname, u.avatar, u.nickname, u.status, s.score, s.mutualFollows, s.sharedGroups, s.sharedEvents, s.sharedChats
+		FROM suggestions s JOIN users u ON u.nickname = s.suggested
+		WHERE s.user = ?
+		AND u.email NOT IN (SELECT followee FROM followers WHERE follower = ?)
+		AND s.suggested NOT IN (SELECT target FROM follow_requests WHERE requester = ? AND state = 'pending')
+		AND s.suggested NOT IN (SELECT requester FROM follow_requests WHERE target = ? AND state = 'pending')
+		AND s.suggested NOT IN (SELECT muted FROM mutes WHERE user = ? AND type = 'user' AND (expires = 0 OR expires > ?))
+		ORDER BY s.score DESC, s.suggested LIMIT ?`,
+		viewer.Nickname, viewer.Email, viewer.Nickname, viewer.Nickname, viewer.Nickname, time.Now().UnixMilli(), limit)
+	if err != nil {
+		fmt.Println("error getting suggestions", err)
+		return sliceOfSuggestions
+	}
+	_, followers := GetFollowSets(viewer.Email)
 	for rows.Next() {
-		rows.Scan(&commentid, &postid, &author, &image, &text, &thread, &time)
-		commentPost = CommentFields{
-			CommentId: commentid,
-			PostId:    postid,
-			Author:    author,
-			Image:     image,
-			Text:      text,
-			Thread:    thread,
-			Time:      time,
-			Likes:     len(GetCommentLikes(commentid, "l")),
-			Dislikes:  len(GetCommentLikes(commentid, "d")),
-		}
-		row, err := PreparedQuery("SELECT * FROM users WHERE nickname = ?", commentPost.Author, db, "GetUserFromPosts")
-		commentPost.AuthorImg = QueryUser(row, err).Avatar
-		if commentPost.Author == user {
-			commentPost.CommentAuthor = true
-		}
-		commentLike := GetCommentLike(commentPost.CommentId, user)
-		if commentLike.Like == "l" {
-			commentPost.CommentLiked = true
-		} else if commentLike.Like == "d" {
-			commentPost.CommentDisliked = true
-		}
+		var user User
+		var status sql.NullString
+		var suggestion SuggestionFields
+		rows.Scan(&user.Email, &user.Firstname, &user.Lastname, &user.Avatar, &user.Nickname, &status, &suggestion.Score,
+			&suggestion.MutualFollows, &suggestion.SharedGroups, &suggestion.SharedEvents, &suggestion.SharedChats)
+		user.Status = status.String
+		suggestion.UserSummaryFields = summariseUser(viewer, user, map[string]bool{}, followers)
+		sliceOfSuggestions = append(sliceOfSuggestions, suggestion)
 	}
 	rows.Close()
-	return commentPost
+	return sliceOfSuggestions
 }
 
 //
-// Comment Likes
+// Mutes
 //
 
-func AddCommentLike(commentLikes CommentsAndLikesFields) error {
-	LikedComment := GetCommentLike(commentLikes.CommentId, commentLikes.Username)
+// Mute types: a user by nickname, a word found in the text, or a hashtag found in the thread.
+var muteTypes = []string{"user", "word", "hashtag"}
+
+func AddMute(muteFields MuteFields) error {
 	db := OpenDB()
 	defer db.Close()
-	var s string
-	if LikedComment.Like == "" {
-		s = "INSERT INTO likescom (like, id, username) values (?, ?, ?)"
-	} else if commentLikes.Like != LikedComment.Like {
-		s = "UPDATE likescom SET like = ? WHERE id = ? AND username = ?"
-	} else {
-		s = "DELETE FROM likescom WHERE like = ? AND id = ? AND username = ?"
+	// Replace an existing mute so a new expiry overrides the old one.
+	_, err := db.Exec("DELETE FROM mutes WHERE user = ? AND muted = ? AND type = ?", muteFields.User, muteFields.Muted, muteFields.Type)
+	if err != nil {
+		fmt.Println("error replacing mute", err)
+		return err
 	}
-	stmt, _ := db.Prepare(s)
-	_, err := stmt.Exec(commentLikes.Like, commentLikes.CommentId, commentLikes.Username)
+	_, err = db.Exec("INSERT INTO mutes (user, muted, type, expires) values (?, ?, ?, ?)", muteFields.User, muteFields.Muted, muteFields.Type, muteFields.Expires)
 	if err != nil {
-		fmt.Println(err)
+		fmt.Println("error adding mute to table", err)
+		return err
 	}
-	return err
+	return nil
 }
 
-func GetCommentLike(id, user string) CommentsAndLikesFields {
-	CommentLikeRow := CommentsAndLikesFields{}
+func RemoveMute(muteFields MuteFields) error {
 	db := OpenDB()
 	defer db.Close()
-	s := fmt.Sprintf("SELECT * FROM likescom WHERE id = '%v' AND username = '%v'", id, user)
-	rows, _ := db.Query(s)
-	var commentId string
-	var author string
-	var like string
-	if rows.Next() {
-		rows.Scan(&commentId, &author, &like)
-		CommentLikeRow = CommentsAndLikesFields{
-			CommentId: commentId,
-			Username:  author,
-			Like:      like,
-		}
+	_, err := db.Exec("DELETE FROM mutes WHERE user = ? AND muted = ? AND type = ?", muteFields.User, muteFields.Muted, muteFields.Type)
+	if err != nil {
+		fmt.Println("error removing mute from table", err)
 	}
-	rows.Close()
-	return CommentLikeRow
+	return err
 }
 
-func GetCommentLikes(id, l string) []CommentsAndLikesFields {
-	sliceOfCommentLikesRow := []CommentsAndLikesFields{}
+// Get the active mutes of a user. Expired mutes are skipped until the scheduler removes them.
+func GetMutes(user string) []MuteFields {
 	db := OpenDB()
 	defer db.Close()
-	var s string
-	if l == "all" {
-		s = fmt.Sprintf("SELECT * FROM likescom WHERE username = '%v' AND like = '%v'", id, "l")
-
-	} else {
-		s = fmt.Sprintf("SELECT * FROM likescom WHERE id = '%v' AND like = '%v'", id, l)
-
+	sliceOfMutes := []MuteFields{}
+	rows, err := db.Query("SELECT user, muted, type, expires FROM mutes WHERE user = ? AND (expires = 0 OR expires > ?)", user, time.Now().UnixMilli())
+	if err != nil {
+		fmt.Println("error getting mutes", err)
+		return sliceOfMutes
 	}
-
-	rows, _ := db.Query(s)
-	var commentId string
-	var author string
-	var like string
+	var muteUser, muted, muteType string
+	var expires int
 	for rows.Next() {
-		rows.Scan(&commentId, &author, &like)
-		likedRows := CommentsAndLikesFields{
-			CommentId: commentId,
-			Username:  author,
-			Like:      like,
-		}
-		sliceOfCommentLikesRow = append(sliceOfCommentLikesRow, likedRows)
+		rows.Scan(&muteUser, &muted, &muteType, &expires)
+		sliceOfMutes = append(sliceOfMutes, MuteFields{
+			User:    muteUser,
+			Muted:   muted,
+			Type:    muteType,
+			Expires: expires,
+		})
 	}
 	rows.Close()
-	return sliceOfCommentLikesRow
+	return sliceOfMutes
 }
 
-// Followers
-
-func GetUserFromFollowMessage(email string) User {
+// Remove mutes that have expired, run by the scheduler.
+func PurgeExpiredMutes() {
 	db := OpenDB()
 	defer db.Close()
-	//get the users who have interacted
-	row, err := PreparedQuery("SELECT * FROM users WHERE email = ?", email, db, "GetUserFromFollowers")
-	return QueryUser(row, err)
+	_, err := db.Exec("DELETE FROM mutes WHERE expires != 0 AND expires <= ?", time.Now().UnixMilli())
+	if err != nil {
+		fmt.Println("error removing expired mutes", err)
+	}
 }
 
-func updateFollowerCount(followerEmail string, followeeEmail string, isFollowing bool) (int, int, error) {
-	// Update the follower count in the database.
-
-	db := OpenDB()
-	defer db.Close()
-
-	// Create a map representing the follow object to update the db.
-	follow := make(map[string]string)
-	follow["follower"] = followerEmail
-	follow["followee"] = followeeEmail
-
-	// Increment if follow button pressed otherwise decrement.
-	if isFollowing {
-		db.Exec("UPDATE users SET followers=followers+1 WHERE email=?", followeeEmail)
-		db.Exec("UPDATE users SET following=following+1 WHERE email=?", followerEmail)
-		PreparedExec("INSERT INTO followers (follower, followee) values (?,?)", follow, db, "updateFollowerCount")
-	} else {
-		db.Exec("UPDATE users SET followers=followers-1 WHERE email=?", followeeEmail)
-		db.Exec("UPDATE users SET following=following-1 WHERE email=?", followerEmail)
-		PreparedExec("DELETE FROM followers WHERE follower=? AND followee=?", follow, db, "updateFollowerCount")
+// Normalise what is being muted. Words and hashtags are matched case insensitively.
+func NormaliseMute(muteFields MuteFields) MuteFields {
+	muteFields.Muted = strings.TrimSpace(muteFields.Muted)
+	if muteFields.Type == "word" {
+		muteFields.Muted = strings.ToLower(muteFields.Muted)
+	} else if muteFields.Type == "hashtag" {
+		muteFields.Muted = "#" + strings.ToLower(strings.TrimLeft(muteFields.Muted, "#"))
 	}
-
-	// Secure sql query and get user based on session, get followee updated following/followers details
-	rows, err := PreparedQuery("SELECT * FROM users WHERE email = ?", followeeEmail, db, "updateFollowerCount")
-	followee := QueryUser(rows, err)
-
-	// get follower updated following/followers details
-	rows1, err1 := PreparedQuery("SELECT * FROM users WHERE email = ?", followerEmail, db, "updateFollowerCount")
-	follower := QueryUser(rows1, err1)
-
-	// Return the new follower count
-	return followee.Followers, follower.Following, nil
+	return muteFields
 }
 
-func GetFollowers(user User) []string {
-	db := OpenDB()
-	defer db.Close()
-	s := fmt.Sprintf("SELECT * FROM followers WHERE follower = '%v' OR followee = '%v'", user.Email, user.Email)
-	rows, _ := db.Query(s)
-	var id int
-	var follower, followee string
-	var friends []string
-	for rows.Next() {
-		err := rows.Scan(&id, &follower, &followee)
-		if err != nil {
-			fmt.Println("error getting friends", err)
-		} else {
-			if follower == user.Email {
-				row, err := PreparedQuery("SELECT * FROM users WHERE email = ?", followee, db, "GetUserFromFollowers")
-				name := QueryUser(row, err).Nickname
-				if !Contains(friends, name) {
-					friends = append(friends, name)
-				}
+// SQL condition removing rows of a posts-like table (author, text and thread columns)
+// that the viewer has muted. Takes the viewer's nickname and the current time in ms as arguments.
+func MutedContentFilter(table string) string {
+	return `NOT EXISTS (SELECT 1 FROM mutes m WHERE m.user = ? AND (m.expires = 0 OR m.expires > ?) AND (
+		(m.type = 'user' AND m.muted = ` + table + `.author) OR
+		(m.type = 'word' AND has_word(IFNULL(` + table + `.text, ''), m.muted)) OR
+		(m.type = 'hashtag' AND instr(',' || lower(replace(IFNULL(` + table + `.thread, ''), ' ', '')) || ',', ',' || m.muted || ',') > 0)))`
+}
 
-			} else {
-				row, err := PreparedQuery("SELECT * FROM users WHERE email = ?", follower, db, "GetUserFromFollowers")
-				name := QueryUser(row, err).Nickname
-				if !Contains(friends, name) {
-					friends = append(friends, name)
-				}
-			}
-		}
+// Split text into lower case words, anything but letters and numbers separating them.
+// This is how the unicode61 tokenizer of the search index splits words as well.
+func textWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !isWordRune(r)
+	})
+}
 
-	}
-	friends = append(friends, user.Nickname)
-	rows.Close()
-	return friends
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
 }
 
-func GetFollowing(user User) []string {
-	db := OpenDB()
-	defer db.Close()
-	s := fmt.Sprintf("SELECT * FROM followers WHERE follower = '%v'", user.Email)
-	rows, err := db.Query(s)
-	if err != nil {
-		fmt.Println("error selecting followees", err)
+// Whether text contains words as whole words, so muting "cat" does not hide "category".
+// A muted phrase matches when its words appear next to each other.
+func HasWord(text, words string) bool {
+	phrase := textWords(words)
+	if len(phrase) == 0 {
+		return false
 	}
-	var id int
-	var follower, followee string
-	var friends []string
-	for rows.Next() {
-		err := rows.Scan(&id, &follower, &followee)
-		if err != nil {
-			fmt.Println("error getting friends", err)
-		} else {
-			row, err := PreparedQuery("SELECT * FROM users WHERE email = ?", followee, db, "GetUserFromFollowers")
-			name := QueryUser(row, err).Nickname
-			if !Contains(friends, name) {
-				friends = append(friends, name)
+	found := textWords(text)
+	for i := 0; i+len(phrase) <= len(found); i++ {
+		match := true
+		for j, word := range phrase {
+			if found[i+j] != word {
+				match = false
+				break
 			}
 		}
-
+		if match {
+			return true
+		}
 	}
-	friends = append(friends, user.Nickname)
-	rows.Close()
-	return friends
+	return false
 }
 
-func GetTotalFollowers(email string) int {
-	db := OpenDB()
-	defer db.Close()
-	s := fmt.Sprintf("SELECT * FROM followers WHERE follower = '%v'", email)
-	rows, _ := db.Query(s)
-	var id int
-	var follower, followee string
-	var totalFollowers []Follow
-	for rows.Next() {
-		err := rows.Scan(&id, &follower, &followee)
-		if err != nil {
-			fmt.Println("error getting friends", err)
-		}
-		followField := Follow{
-			Follower: follower,
-			Followee: followee,
+// Check whether a piece of content would be hidden from the viewer by their mutes.
+// Used for realtime messages that never go through a feed query.
+func IsMutedContent(viewer, author, text, thread string) bool {
+	threads := strings.Split(strings.ToLower(strings.ReplaceAll(thread, " ", "")), ",")
+	for _, mute := range GetMutes(viewer) {
+		switch mute.Type {
+		case "user":
+			if mute.Muted == author {
+				return true
+			}
+		case "word":
+			if HasWord(text, mute.Muted) {
+				return true
+			}
+		case "hashtag":
+			if Contains(threads, mute.Muted) {
+				return true
+			}
 		}
-		totalFollowers = append(totalFollowers, followField)
 	}
-	rows.Close()
-
-	return len(totalFollowers)
+	return false
 }
 
 //
@@ -1668,8 +4700,9 @@ func GetAllRequestNotifs(user string) []RequestNotifcationFields {
 	db := OpenDB()
 	defer db.Close()
 	sliceOfRequestFields := []RequestNotifcationFields{}
-	n := fmt.Sprintf(`SELECT * FROM requestNotification WHERE receiver = '%v'`, user)
-	rows, err := db.Query(n)
+	// Notifications sent by muted users are hidden but kept in the table.
+	n := `SELECT * FROM requestNotification WHERE receiver = ? AND sender NOT IN (SELECT muted FROM mutes WHERE user = ? AND type = 'user' AND (expires = 0 OR expires > ?))`
+	rows, err := db.Query(n, user, user, time.Now().UnixMilli())
 	var sender, receiver, typeOfRequest, groupId string
 	if err != nil {
 		fmt.Println(err, "error getting TotalRequestNotifciations")
@@ -1950,8 +4983,22 @@ func GetEventAttendees(id string) []EventAttendanceFields {
 // DB
 //
 
+// The sqlite3 driver with the functions queries here rely on: has_word(text, words) matches
+// word mutes in SQL exactly as HasWord does in Go, and search_match(text, terms) matches
+// search terms as SearchMatches does.
+func init() {
+	sql.Register("sqlite3_social", &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if err := conn.RegisterFunc("has_word", HasWord, true); err != nil {
+				return err
+			}
+			return conn.RegisterFunc("search_match", SearchMatches, true)
+		},
+	})
+}
+
 func OpenDB() *sql.DB {
-	db, err := sql.Open("sqlite3", "backend/pkg/db/sqlite/sNetwork.db")
+	db, err := sql.Open("sqlite3_social", "backend/pkg/db/sqlite/sNetwork.db")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -1963,6 +5010,11 @@ func CreateSqlTables() {
 
 	db := OpenDB()
 
+	// Readers and writers do not block each other in WAL mode. Link previews are saved in the background
+	// while requests read, and a writer waiting on a long read would otherwise stall both.
+	var _, walError = db.Exec("PRAGMA journal_mode=WAL")
+	CheckErr(walError, "-------Error setting journal mode")
+
 	// if you need to delete a table rather than delete a whole database
 	// _, deleteTblErr := db.Exec(`DROP TABLE IF EXISTS "followers"`)
 	// CheckErr(deleteTblErr, "-------Error deleting table")
@@ -1988,18 +5040,26 @@ func CreateSqlTables() {
 	var _, postTblErr = db.Exec("CREATE TABLE IF NOT EXISTS `posts` ( `id` TEXT NOT NULL UNIQUE, `author` TEXT NOT NULL, `image` TEXT,`text` TEXT,`thread` TEXT, `time` NUMBER,`privacy` TEXT NOT NULL, `viewers` TEXT)")
 	CheckErr(postTblErr, "-------Error creating table")
 
-	// Create Likes table if not exists
-	var _, likesTblErr = db.Exec("CREATE TABLE IF NOT EXISTS `likes` (`id` TEXT NOT NULL, `username` TEXT NOT NULL, `like` TEXT)")
-	CheckErr(likesTblErr, "-------Error creating table")
+	// Create reactions table if not exists. Holds every user's reaction to posts, comments and group posts and comments.
+	var _, reactionsTblErr = db.Exec("CREATE TABLE IF NOT EXISTS `reactions` (`targetType` TEXT NOT NULL, `targetId` TEXT NOT NULL, `user` TEXT NOT NULL, `reaction` TEXT NOT NULL, `time` NUMBER, PRIMARY KEY (`targetType`, `targetId`, `user`))")
+	CheckErr(reactionsTblErr, "-------Error creating table")
+
+	// Create polls tables if not exists. A ballot is a user's single vote on a poll, covering one or more options.
+	for _, table := range []string{
+		"CREATE TABLE IF NOT EXISTS `polls` (`id` TEXT NOT NULL PRIMARY KEY, `targetType` TEXT NOT NULL, `targetId` TEXT NOT NULL, `groupId` TEXT, `multiple` BOOLEAN DEFAULT 0, `closesAt` NUMBER DEFAULT 0, `hideResults` BOOLEAN DEFAULT 0, `announced` BOOLEAN DEFAULT 0)",
+		"CREATE UNIQUE INDEX IF NOT EXISTS `polls_target` ON `polls` (`targetType`, `targetId`)",
+		"CREATE TABLE IF NOT EXISTS `pollOptions` (`pollId` TEXT NOT NULL, `id` TEXT NOT NULL, `text` TEXT NOT NULL, `position` NUMBER, PRIMARY KEY (`pollId`, `id`))",
+		"CREATE TABLE IF NOT EXISTS `pollBallots` (`pollId` TEXT NOT NULL, `user` TEXT NOT NULL, `time` NUMBER, PRIMARY KEY (`pollId`, `user`))",
+		"CREATE TABLE IF NOT EXISTS `pollVotes` (`pollId` TEXT NOT NULL, `optionId` TEXT NOT NULL, `user` TEXT NOT NULL, `time` NUMBER, PRIMARY KEY (`pollId`, `optionId`, `user`))",
+	} {
+		var _, pollsError = db.Exec(table)
+		CheckErr(pollsError, "-------Error creating table")
+	}
 
 	// Create comments table if not exists
 	var _, commentError = db.Exec("CREATE TABLE IF NOT EXISTS `comments` (`id` TEXT NOT NULL UNIQUE, `postid` TEXT NOT NULL, `author` TEXT NOT NULL, `image` TEXT, `text` TEXT, `thread` TEXT, `time` NUMBER)")
 	CheckErr(commentError, "-------Error creating table")
 
-	// Create  Comment Likes table if not exists
-	var _, CommentLikesTblErr = db.Exec("CREATE TABLE IF NOT EXISTS `likescom` (`id` TEXT NOT NULL, `username` TEXT NOT NULL, `like` TEXT)")
-	CheckErr(CommentLikesTblErr, "-------Error creating table")
-
 	// Create followers table if not exists
 	var _, followErr = db.Exec("CREATE TABLE IF NOT EXISTS `followers` (`id` INTEGER PRIMARY KEY AUTOINCREMENT, `follower` VARCHAR(64), `followee` VARCHAR(64))")
 	CheckErr(followErr, "-------Error creating table")
@@ -2020,10 +5080,6 @@ func CreateSqlTables() {
 	var _, GroupPostTblErr = db.Exec("CREATE TABLE IF NOT EXISTS `groupposts` ( `id` TEXT, `postid` TEXT NOT NULL UNIQUE, `author` TEXT NOT NULL, `image` TEXT,`text` TEXT,`thread` TEXT, `time` NUMBER)")
 	CheckErr(GroupPostTblErr, "-------Error creating table")
 
-	// Create  Group Post Likes table if not exists
-	var _, GroupPostLikesTblErr = db.Exec("CREATE TABLE IF NOT EXISTS `likesgroup` (`id` TEXT NOT NULL, `username` TEXT NOT NULL, `like` TEXT)")
-	CheckErr(GroupPostLikesTblErr, "-------Error creating table")
-
 	// Create group post comments table if not exists
 	var _, groupCommentError = db.Exec("CREATE TABLE IF NOT EXISTS `groupComments` (`id` TEXT NOT NULL UNIQUE, `postid` TEXT NOT NULL, `author` TEXT NOT NULL, `image` TEXT, `text` TEXT, `thread` TEXT, `time` NUMBER)")
 	CheckErr(groupCommentError, "-------Error creating table")
@@ -2034,6 +5090,129 @@ func CreateSqlTables() {
 
 	var _, eventAttendanceError = db.Exec("CREATE TABLE IF NOT EXISTS `eventAttendance` (`eventId` TEXT, `user` TEXT NOT NULL, `status` TEXT)")
 	CheckErr(eventAttendanceError, "-------Error creating table")
+
+	// Create presence table if not exists. Holds last seen time and whether presence is hidden.
+	var _, presenceError = db.Exec("CREATE TABLE IF NOT EXISTS `presence` (`user` TEXT NOT NULL PRIMARY KEY, `lastSeen` NUMBER DEFAULT 0, `hidden` BOOLEAN DEFAULT 0)")
+	CheckErr(presenceError, "-------Error creating table")
+
+	// Create follow requests table if not exists. State is pending, accepted, declined or cancelled.
+	var _, followRequestsError = db.Exec("CREATE TABLE IF NOT EXISTS `follow_requests` (`id` INTEGER PRIMARY KEY AUTOINCREMENT, `requester` TEXT NOT NULL, `target` TEXT NOT NULL, `state` TEXT NOT NULL, `created` NUMBER, `updated` NUMBER)")
+	CheckErr(followRequestsError, "-------Error creating table")
+	var _, followRequestsIndexError = db.Exec("CREATE INDEX IF NOT EXISTS `follow_requests_target` ON `follow_requests` (`target`, `state`)")
+	CheckErr(followRequestsIndexError, "-------Error creating index")
+
+	// Indexes used by the feed and comment counts.
+	for _, index := range []string{
+		"CREATE INDEX IF NOT EXISTS `posts_time` ON `posts` (`time`, `id`)",
+		"CREATE INDEX IF NOT EXISTS `posts_author_time` ON `posts` (`author`, `time`)",
+		"CREATE INDEX IF NOT EXISTS `groupposts_group_time` ON `groupposts` (`id`, `time`)",
+		"CREATE INDEX IF NOT EXISTS `users_nickname_nocase` ON `users` (`nickname` COLLATE NOCASE)",
+		"CREATE INDEX IF NOT EXISTS `users_firstname_nocase` ON `users` (`firstname` COLLATE NOCASE)",
+		"CREATE INDEX IF NOT EXISTS `users_lastname_nocase` ON `users` (`lastname` COLLATE NOCASE)",
+		"CREATE INDEX IF NOT EXISTS `comments_postid_time` ON `comments` (`postid`, `time`, `id`)",
+		"CREATE INDEX IF NOT EXISTS `groupComments_postid_time` ON `groupComments` (`postid`, `time`, `id`)",
+		"CREATE INDEX IF NOT EXISTS `followers_follower` ON `followers` (`follower`, `followee`)",
+		"CREATE INDEX IF NOT EXISTS `followers_followee` ON `followers` (`followee`)",
+		"CREATE INDEX IF NOT EXISTS `follow_requests_requester` ON `follow_requests` (`requester`, `state`)",
+	} {
+		var _, indexError = db.Exec(index)
+		CheckErr(indexError, "-------Error creating index")
+	}
+	// Comments by post are read through the postid_time indexes, which lead with postid.
+	for _, index := range []string{"comments_postid", "groupComments_postid"} {
+		var _, dropError = db.Exec("DROP INDEX IF EXISTS `" + index + "`")
+		CheckErr(dropError, "-------Error dropping index")
+	}
+
+	// Create audiences tables if not exists. Posts with "audience" privacy keep the audience id in viewers.
+	var _, audiencesError = db.Exec("CREATE TABLE IF NOT EXISTS `audiences` (`id` TEXT NOT NULL PRIMARY KEY, `owner` TEXT NOT NULL, `name` TEXT NOT NULL, `builtIn` BOOLEAN DEFAULT 0)")
+	CheckErr(audiencesError, "-------Error creating table")
+	var _, audienceMembersError = db.Exec("CREATE TABLE IF NOT EXISTS `audienceMembers` (`audienceId` TEXT NOT NULL, `member` TEXT NOT NULL, UNIQUE(`audienceId`, `member`))")
+	CheckErr(audienceMembersError, "-------Error creating table")
+
+	// Create link preview tables if not exist. Previews are cached by url, items point at the url of their first link.
+	var _, linkPreviewsError = db.Exec("CREATE TABLE IF NOT EXISTS `linkPreviews` (`url` TEXT NOT NULL PRIMARY KEY, `title` TEXT, `description` TEXT, `image` TEXT, `siteName` TEXT, `fetched` NUMBER, `failed` BOOLEAN DEFAULT 0)")
+	CheckErr(linkPreviewsError, "-------Error creating table")
+	var _, previewLinksError = db.Exec("CREATE TABLE IF NOT EXISTS `previewLinks` (`targetType` TEXT NOT NULL, `targetId` TEXT NOT NULL, `url` TEXT NOT NULL, PRIMARY KEY (`targetType`, `targetId`))")
+	CheckErr(previewLinksError, "-------Error creating table")
+
+	// Create stories tables if not exist. Stories are removed once they expire.
+	var _, storiesError = db.Exec("CREATE TABLE IF NOT EXISTS `stories` (`id` TEXT NOT NULL PRIMARY KEY, `author` TEXT NOT NULL, `image` TEXT, `text` TEXT, `created` NUMBER, `expires` NUMBER)")
+	CheckErr(storiesError, "-------Error creating table")
+	var _, storiesIndexError = db.Exec("CREATE INDEX IF NOT EXISTS `stories_expires` ON `stories` (`expires`)")
+	CheckErr(storiesIndexError, "-------Error creating index")
+	var _, storyViewsError = db.Exec("CREATE TABLE IF NOT EXISTS `storyViews` (`storyId` TEXT NOT NULL, `viewer` TEXT NOT NULL, `time` NUMBER, PRIMARY KEY (`storyId`, `viewer`))")
+	CheckErr(storyViewsError, "-------Error creating table")
+
+	// Create media table if not exists. Files live in MediaDir, named by id.
+	var _, mediaError = db.Exec("CREATE TABLE IF NOT EXISTS `media` (`id` TEXT NOT NULL PRIMARY KEY, `owner` TEXT NOT NULL, `mime` TEXT NOT NULL, `size` NUMBER, `width` NUMBER, `height` NUMBER, `thumbnails` TEXT, `created` NUMBER)")
+	CheckErr(mediaError, "-------Error creating table")
+
+	// Create suggestions table if not exists. Filled by the suggestions job.
+	var _, suggestionsError = db.Exec("CREATE TABLE IF NOT EXISTS `suggestions` (`user` TEXT NOT NULL, `suggested` TEXT NOT NULL, `score` NUMBER, `mutualFollows` NUMBER, `sharedGroups` NUMBER, `sharedEvents` NUMBER, `sharedChats` NUMBER, `computed` NUMBER)")
+	CheckErr(suggestionsError, "-------Error creating table")
+	var _, suggestionsIndexError = db.Exec("CREATE INDEX IF NOT EXISTS `suggestions_user` ON `suggestions` (`user`, `score`)")
+	CheckErr(suggestionsIndexError, "-------Error creating index")
+
+	// Create hashtag tables if not exists. Links point at posts, comments, group posts and group comments.
+	var _, tagsError = db.Exec("CREATE TABLE IF NOT EXISTS `tags` (`id` INTEGER PRIMARY KEY AUTOINCREMENT, `name` TEXT NOT NULL UNIQUE)")
+	CheckErr(tagsError, "-------Error creating table")
+	var _, tagLinksError = db.Exec("CREATE TABLE IF NOT EXISTS `tagLinks` (`tagId` INTEGER NOT NULL REFERENCES tags(id), `targetType` TEXT NOT NULL, `targetId` TEXT NOT NULL, `time` NUMBER, PRIMARY KEY (`tagId`, `targetType`, `targetId`))")
+	CheckErr(tagLinksError, "-------Error creating table")
+	var _, tagLinksTargetError = db.Exec("CREATE INDEX IF NOT EXISTS `tagLinks_target` ON `tagLinks` (`targetType`, `targetId`)")
+	CheckErr(tagLinksTargetError, "-------Error creating index")
+	var _, tagLinksTimeError = db.Exec("CREATE INDEX IF NOT EXISTS `tagLinks_time` ON `tagLinks` (`time`)")
+	CheckErr(tagLinksTimeError, "-------Error creating index")
+
+	// Create bookmarks tables if not exists. Bookmarks outside a collection have an empty collectionId.
+	var _, collectionsError = db.Exec("CREATE TABLE IF NOT EXISTS `collections` (`id` TEXT NOT NULL PRIMARY KEY, `owner` TEXT NOT NULL, `name` TEXT NOT NULL, `created` NUMBER, UNIQUE(`owner`, `name`))")
+	CheckErr(collectionsError, "-------Error creating table")
+	var _, bookmarksError = db.Exec("CREATE TABLE IF NOT EXISTS `bookmarks` (`owner` TEXT NOT NULL, `targetType` TEXT NOT NULL, `targetId` TEXT NOT NULL, `collectionId` TEXT NOT NULL DEFAULT '', `created` NUMBER, PRIMARY KEY (`owner`, `targetType`, `targetId`, `collectionId`))")
+	CheckErr(bookmarksError, "-------Error creating table")
+	var _, bookmarksCreatedError = db.Exec("CREATE INDEX IF NOT EXISTS `bookmarks_owner_created` ON `bookmarks` (`owner`, `created`)")
+	CheckErr(bookmarksCreatedError, "-------Error creating index")
+
+	// Create reposts table if not exists. Reposts and quotes are rows of posts linked to their original here.
+	var _, repostsError = db.Exec("CREATE TABLE IF NOT EXISTS `reposts` (`postId` TEXT NOT NULL PRIMARY KEY, `originalId` TEXT NOT NULL, `kind` TEXT NOT NULL, `originalDeleted` BOOLEAN DEFAULT 0)")
+	CheckErr(repostsError, "-------Error creating table")
+	var _, repostsOriginalError = db.Exec("CREATE INDEX IF NOT EXISTS `reposts_original` ON `reposts` (`originalId`)")
+	CheckErr(repostsOriginalError, "-------Error creating index")
+
+	// Create drafts table if not exists. Kind is post or group-post; state is draft, scheduled, publishing or failed.
+	var _, draftsError = db.Exec("CREATE TABLE IF NOT EXISTS `drafts` (`id` TEXT NOT NULL PRIMARY KEY, `author` TEXT NOT NULL, `kind` TEXT NOT NULL, `groupId` TEXT, `image` TEXT, `text` TEXT, `thread` TEXT, `privacy` TEXT, `viewers` TEXT, `state` TEXT NOT NULL, `publishAt` NUMBER, `created` NUMBER, `updated` NUMBER, `error` TEXT)")
+	CheckErr(draftsError, "-------Error creating table")
+	var _, draftsDueError = db.Exec("CREATE INDEX IF NOT EXISTS `drafts_due` ON `drafts` (`state`, `publishAt`)")
+	CheckErr(draftsDueError, "-------Error creating index")
+
+	// Create revisions table if not exists. Holds every version of edited posts and comments.
+	var _, revisionsError = db.Exec("CREATE TABLE IF NOT EXISTS `revisions` (`targetType` TEXT NOT NULL, `targetId` TEXT NOT NULL, `author` TEXT NOT NULL, `image` TEXT, `text` TEXT, `thread` TEXT, `time` NUMBER)")
+	CheckErr(revisionsError, "-------Error creating table")
+	var _, revisionsTargetError = db.Exec("CREATE INDEX IF NOT EXISTS `revisions_target` ON `revisions` (`targetType`, `targetId`, `time`)")
+	CheckErr(revisionsTargetError, "-------Error creating index")
+
+	// Create mentions table if not exists. Rows double as mention notifications for the mentioned user.
+	var _, mentionsError = db.Exec("CREATE TABLE IF NOT EXISTS `mentions` (`targetType` TEXT NOT NULL, `targetId` TEXT NOT NULL, `context` TEXT, `author` TEXT NOT NULL, `mentioned` TEXT NOT NULL, `time` NUMBER, `seen` BOOLEAN DEFAULT 0, `active` BOOLEAN DEFAULT 1, PRIMARY KEY (`targetType`, `targetId`, `mentioned`))")
+	CheckErr(mentionsError, "-------Error creating table")
+	var _, mentionsUserError = db.Exec("CREATE INDEX IF NOT EXISTS `mentions_mentioned` ON `mentions` (`mentioned`, `time`)")
+	CheckErr(mentionsUserError, "-------Error creating index")
+
+	// Create mutes table if not exists. Expires is 0 for mutes without an expiry.
+	var _, mutesError = db.Exec("CREATE TABLE IF NOT EXISTS `mutes` (`user` TEXT NOT NULL, `muted` TEXT NOT NULL, `type` TEXT NOT NULL, `expires` NUMBER DEFAULT 0)")
+	CheckErr(mutesError, "-------Error creating table")
+
+	// Create comment replies table if not exists. Links replies to the comment or group comment they answer.
+	var _, repliesError = db.Exec("CREATE TABLE IF NOT EXISTS `commentReplies` (`targetType` TEXT NOT NULL, `commentId` TEXT NOT NULL, `parentId` TEXT NOT NULL, `depth` NUMBER NOT NULL, PRIMARY KEY (`targetType`, `commentId`))")
+	CheckErr(repliesError, "-------Error creating table")
+	var _, repliesParentError = db.Exec("CREATE INDEX IF NOT EXISTS `commentReplies_parent` ON `commentReplies` (`targetType`, `parentId`)")
+	CheckErr(repliesParentError, "-------Error creating index")
+
+	// Create reply notifications table if not exists. Sent to the post author and the author of the comment replied to.
+	var _, replyNotificationsError = db.Exec("CREATE TABLE IF NOT EXISTS `replyNotifications` (`targetType` TEXT NOT NULL, `commentId` TEXT NOT NULL, `postId` TEXT NOT NULL, `author` TEXT NOT NULL, `receiver` TEXT NOT NULL, `time` NUMBER, `seen` BOOLEAN DEFAULT 0, PRIMARY KEY (`targetType`, `commentId`, `receiver`))")
+	CheckErr(replyNotificationsError, "-------Error creating table")
+	var _, replyNotificationsReceiverError = db.Exec("CREATE INDEX IF NOT EXISTS `replyNotifications_receiver` ON `replyNotifications` (`receiver`, `time`)")
+	CheckErr(replyNotificationsReceiverError, "-------Error creating index")
+
+	CreateSearchIndex(db)
 	db.Close()
 
 }
@@ -2081,6 +5260,47 @@ func JsonMessage(message string) []byte {
 
 }
 
+// Opaque pagination cursor holding the sort key of the last item returned.
+func EncodeCursor(key ...string) string {
+	jsonKey, _ := json.Marshal(key)
+	return base64.RawURLEncoding.EncodeToString(jsonKey)
+}
+
+// Decode a cursor made by EncodeCursor. An empty cursor is the first page and returns nil.
+func DecodeCursor(cursor string) ([]string, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	jsonKey, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	var key []string
+	err = json.Unmarshal(jsonKey, &key)
+	return key, err
+}
+
+func compareCursorKeys(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return strings.Compare(a[i], b[i])
+		}
+	}
+	return len(a) - len(b)
+}
+
+// Read the page size from the limit query parameter, keeping it between 1 and max.
+func PageLimit(r *http.Request, defaultLimit, max int) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		return defaultLimit
+	}
+	if limit > max {
+		return max
+	}
+	return limit
+}
+
 // More secure sql query. Return rows.
 func PreparedQuery(query string, input string, db *sql.DB, functionName string) (*sql.Rows, error) {
 	stmt, err := db.Prepare(query)
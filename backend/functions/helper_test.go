@@ -0,0 +1,125 @@
+package functions
+
+import (
+	"testing"
+	"time"
+)
+
+var wordMuteTests = []struct {
+	name  string
+	text  string
+	muted string
+	want  bool
+}{
+	{"word", "I love my cat", "cat", true},
+	{"word at the start", "cat pictures", "cat", true},
+	{"start of a longer word", "a new category", "cat", false},
+	{"end of a longer word", "concat the lists", "cat", false},
+	{"punctuation around the word", "(cat), cat! cat?", "cat", true},
+	{"apostrophe", "the cat's toy", "cat", true},
+	{"upper case text", "THE CAT SAT", "cat", true},
+	{"upper case mute", "the cat sat", "Cat", true},
+	{"accented word", "un café noir", "café", true},
+	{"accent missing", "un cafe noir", "café", false},
+	{"number", "chapter 2 is out", "2", true},
+	{"number in a word", "v2 is out", "2", false},
+	{"phrase", "spoiler alert: he wins", "spoiler alert", true},
+	{"phrase across punctuation", "spoiler, alert", "spoiler alert", true},
+	{"phrase with extra spaces", "game  of   thrones", "game of thrones", true},
+	{"phrase in the wrong order", "alert spoiler", "spoiler alert", false},
+	{"phrase with a word between", "spoiler free alert", "spoiler alert", false},
+	{"phrase inside longer words", "spoilers alerted", "spoiler alert", false},
+	{"empty text", "", "cat", false},
+	{"empty mute", "cat", "", false},
+	{"punctuation mute", "what?!", "?!", false},
+}
+
+func TestHasWord(t *testing.T) {
+	for _, tt := range wordMuteTests {
+		if got := HasWord(tt.text, tt.muted); got != tt.want {
+			t.Errorf("%v: HasWord(%q, %q) = %v, want %v", tt.name, tt.text, tt.muted, got, tt.want)
+		}
+	}
+}
+
+// Feeds filter mutes in SQL through has_word while realtime messages use IsMutedContent:
+// both have to hide the same content.
+func TestMutedContentFilterMatchesIsMutedContent(t *testing.T) {
+	db := OpenDB()
+	defer db.Close()
+	query := "SELECT COUNT(*) FROM (SELECT ? AS author, ? AS text, ? AS thread) p WHERE " + MutedContentFilter("p")
+	for _, tt := range wordMuteTests {
+		var hasWord bool
+		if err := db.QueryRow("SELECT has_word(?, ?)", tt.text, tt.muted).Scan(&hasWord); err != nil {
+			t.Fatal(err)
+		}
+		if hasWord != tt.want {
+			t.Errorf("%v: has_word(%q, %q) = %v, want %v", tt.name, tt.text, tt.muted, hasWord, tt.want)
+		}
+
+		mute := NormaliseMute(MuteFields{User: "mute-viewer", Muted: tt.muted, Type: "word"})
+		if err := AddMute(mute); err != nil {
+			t.Fatal(err)
+		}
+		var shown int
+		if err := db.QueryRow(query, "mute-author", tt.text, "", "mute-viewer", time.Now().UnixMilli()).Scan(&shown); err != nil {
+			t.Fatal(err)
+		}
+		if muted := IsMutedContent("mute-viewer", "mute-author", tt.text, ""); muted != (shown == 0) || muted != tt.want {
+			t.Errorf("%v: IsMutedContent = %v, MutedContentFilter shows %v row(s), want muted %v", tt.name, muted, shown, tt.want)
+		}
+		if err := RemoveMute(mute); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNameMatchScore(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		user  string
+		want  int
+	}{
+		{"exact", "alice", "alice", 0},
+		{"exact in another case", "alice", "Alice", 0},
+		{"prefix", "ali", "alice", 1},
+		{"empty name", "alice", "", -1},
+		{"typo under 3 characters", "ax", "alice", -1},
+		{"one typo at 3 characters", "alx", "alice", 3},
+		{"two typos at 3 characters", "axx", "alice", -1},
+		{"one typo at 5 characters", "alixe", "alice", 3},
+		{"two typos at 5 characters", "axixe", "alice", -1},
+		{"two typos at 6 characters", "jxnaxh", "jonathan", 4},
+		{"three typos at 6 characters", "jxnxxh", "jonathan", -1},
+		{"missing letter", "jonthan", "jonathan", 3},
+		{"extra letter", "allice", "alice", 3},
+		{"prefix one longer than the query", "alce", "alicexyz", 3},
+		{"match past the prefix window", "abc", "axxxxbc", -1},
+	}
+	for _, tt := range tests {
+		if got := nameMatchScore(tt.query, tt.user); got != tt.want {
+			t.Errorf("%v: nameMatchScore(%q, %q) = %v, want %v", tt.name, tt.query, tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"abc", "abc", 0},
+		{"kitten", "sitting", 3},
+		{"flaw", "lawn", 2},
+		{"café", "cafe", 1},
+	}
+	for _, tt := range tests {
+		if got := editDistance([]rune(tt.a), []rune(tt.b)); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
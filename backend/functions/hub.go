@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 )
 
 type message struct {
@@ -18,6 +19,27 @@ type subscription struct {
 	groupRoom string
 	name      string
 	sessionId string
+	// idle is set when the tab reports no recent activity.
+	idle bool
+}
+
+type presenceChange struct {
+	sub  *subscription
+	idle bool
+}
+
+// A message for every connection of some users. It goes through the broadcast channel
+// so the hub's goroutine, the only one allowed to read the connection maps, delivers it.
+type directMessage struct {
+	receivers []string
+	data      interface{}
+}
+
+// A request for the presence of users, answered by the hub's goroutine since
+// only it may read the connection maps.
+type presenceQuery struct {
+	names []string
+	reply chan map[string]string
 }
 
 // hub maintains the set of active connections and broadcasts messages to the
@@ -39,15 +61,27 @@ type hub struct {
 
 	// Unregister requests from connections.
 	unregister chan *subscription
+
+	// Idle and active reports from user connections.
+	presence chan presenceChange
+
+	// Presence lookups from outside the hub.
+	presenceQueries chan presenceQuery
+
+	// Lookups of the users online from outside the hub.
+	onlineQueries chan chan []string
 }
 
 var H = hub{
-	broadcast:  make(chan message),
-	register:   make(chan *subscription),
-	unregister: make(chan *subscription),
-	rooms:      make(map[string]map[*subscription]bool),
-	groupRooms: make(map[string]map[*subscription]bool),
-	user:       make(map[string]map[*subscription]bool),
+	broadcast:       make(chan message),
+	register:        make(chan *subscription),
+	unregister:      make(chan *subscription),
+	presence:        make(chan presenceChange),
+	presenceQueries: make(chan presenceQuery),
+	onlineQueries:   make(chan chan []string),
+	rooms:           make(map[string]map[*subscription]bool),
+	groupRooms:      make(map[string]map[*subscription]bool),
+	user:            make(map[string]map[*subscription]bool),
 }
 
 func (h *hub) Run() {
@@ -69,12 +103,14 @@ func (h *hub) Run() {
 				}
 				h.groupRooms[s.groupRoom][s] = true
 			} else {
+				previousPresence := h.presenceOf(s.name)
 				userConnections := h.user[s.name]
 				if userConnections == nil {
 					userConnections = make(map[*subscription]bool)
 					h.user[s.name] = userConnections
 				}
 				h.user[s.name][s] = true
+				h.presenceChanged(s.name, previousPresence)
 			}
 		case s := <-h.unregister:
 			if s.room != "" {
@@ -102,6 +138,7 @@ func (h *hub) Run() {
 					}
 				}
 			} else {
+				previousPresence := h.presenceOf(s.name)
 				userConnections := h.user[s.name]
 				if userConnections != nil {
 					if _, ok := userConnections[s]; ok {
@@ -113,7 +150,26 @@ func (h *hub) Run() {
 						}
 					}
 				}
+				h.presenceChanged(s.name, previousPresence)
+			}
+		case change := <-h.presence:
+			if h.user[change.sub.name][change.sub] {
+				previousPresence := h.presenceOf(change.sub.name)
+				change.sub.idle = change.idle
+				h.presenceChanged(change.sub.name, previousPresence)
+			}
+		case query := <-h.presenceQueries:
+			presence := make(map[string]string, len(query.names))
+			for _, name := range query.names {
+				presence[name] = h.presenceOf(name)
+			}
+			query.reply <- presence
+		case reply := <-h.onlineQueries:
+			names := make([]string, 0, len(h.user))
+			for name := range h.user {
+				names = append(names, name)
 			}
+			reply <- names
 		case m := <-h.broadcast:
 			switch m.incomingData.(type) {
 			case ChatFields:
@@ -181,12 +237,34 @@ func (h *hub) Run() {
 							if !groupRequestExists {
 								groupFieldsData.Users = member
 								SqlExec.GroupFieldsData <- groupFieldsData
-								userSub.conn.send <- message{incomingData: RequestNotifcationFields{GroupRequest: groupFieldsData}}
+								if !IsMutedContent(member, groupFieldsData.Admin, "", "") {
+									userSub.conn.send <- message{incomingData: RequestNotifcationFields{GroupRequest: groupFieldsData}}
+								}
 							}
 						}
 					}
 				}
 				groupFieldsData.Action = ""
+			case directMessage:
+				directData := m.incomingData.(directMessage)
+				for _, name := range directData.receivers {
+					for s := range h.user[name] {
+						select {
+						case s.conn.send <- message{incomingData: directData.data}:
+						default:
+						}
+					}
+				}
+			case presenceMessage:
+				presenceData := m.incomingData.(presenceMessage)
+				for _, name := range presenceData.audience {
+					for s := range h.user[name] {
+						select {
+						case s.conn.send <- m:
+						default:
+						}
+					}
+				}
 			case GroupPostFields:
 				groupPostData := m.incomingData.(GroupPostFields)
 				subscriptions := h.groupRooms[groupPostData.Id]
@@ -201,11 +279,87 @@ func (h *hub) Run() {
 						}
 					}
 				}
+			case PollFields:
+				pollData := m.incomingData.(PollFields)
+				subscriptions := h.groupRooms[pollData.GroupId]
+				for s := range subscriptions {
+					select {
+					case s.conn.send <- m:
+					default:
+						close(s.conn.send)
+						delete(subscriptions, s)
+						if len(subscriptions) == 0 {
+							delete(h.groupRooms, pollData.GroupId)
+						}
+					}
+				}
 			}
 		}
 	}
 }
 
+// Presence of a user across all their tabs: online if any tab is active,
+// idle if every tab is idle and offline without a user connection.
+func (h *hub) presenceOf(name string) string {
+	userConnections := h.user[name]
+	if len(userConnections) == 0 {
+		return "offline"
+	}
+	for s := range userConnections {
+		if !s.idle {
+			return "online"
+		}
+	}
+	return "idle"
+}
+
+// Presence of each of the users, safe to call from any goroutine but the hub's.
+func (h *hub) Presence(names []string) map[string]string {
+	reply := make(chan map[string]string, 1)
+	h.presenceQueries <- presenceQuery{names: names, reply: reply}
+	return <-reply
+}
+
+// The users with a connection open, safe to call from any goroutine but the hub's.
+func (h *hub) OnlineUsers() []string {
+	reply := make(chan []string, 1)
+	h.onlineQueries <- reply
+	return <-reply
+}
+
+// Push data to every connection of the receivers. The hand-off to the hub never blocks the
+// caller, as the hub itself waits on the statement goroutine that saves chat mentions.
+func (h *hub) SendTo(receivers []string, data interface{}) {
+	go func() {
+		h.broadcast <- message{incomingData: directMessage{receivers: receivers, data: data}}
+	}()
+}
+
+// Push a presence change to the user's followers and chat partners. Going offline
+// also stores the last seen time. The database work is done off the hub's goroutine.
+func (h *hub) presenceChanged(name, previousPresence string) {
+	currentPresence := h.presenceOf(name)
+	if name == "" || currentPresence == previousPresence {
+		return
+	}
+	go func() {
+		lastSeen := GetLastSeen(name)
+		if currentPresence == "offline" {
+			lastSeen = int(time.Now().UnixMilli())
+			SetLastSeen(name, lastSeen)
+		}
+		if GetPresenceSettings(name).Hidden {
+			return
+		}
+		h.broadcast <- message{incomingData: presenceMessage{
+			User:     name,
+			Status:   currentPresence,
+			LastSeen: lastSeen,
+			audience: GetPresenceAudience(name),
+		}}
+	}()
+}
+
 type sqlExecute struct {
 	chatData                chan ChatFields
 	chatNotifData           chan ChatNotifcationFields
@@ -238,6 +392,7 @@ func (d *sqlExecute) ExecuteStatements() {
 			user := GetUserFromFollowMessage(followNotif.ToFollow)
 			sender := GetUserFromFollowMessage(followNotif.FollowRequest)
 			AddRequestNotif(sender.Nickname, user.Nickname, "followRequest", "")
+			AddFollowRequest(sender.Nickname, user.Nickname)
 		case groupFieldsData := <-SqlExec.GroupFieldsData:
 
 			if groupFieldsData.Action == "remove" {
@@ -251,6 +406,11 @@ func (d *sqlExecute) ExecuteStatements() {
 			}
 		case requestNotif := <-SqlExec.RequestNotificationData:
 			DeleteRequestNotif(requestNotif)
+			// Closing a follow request without accepting it declines the request, so it is
+			// not accepted later on when the account goes public.
+			if requestNotif.GroupId == "" && requestNotif.TypeOfAction != acceptedFollowRequest {
+				UpdateFollowRequestState(GetPendingFollowRequest(requestNotif.Sender, requestNotif.Receiver).Id, "declined")
+			}
 		}
 	}
 }
@@ -0,0 +1,413 @@
+package functions
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Directory media files are stored in, set with the MEDIA_DIR environment variable.
+var MediaDir = mediaDirFromEnv()
+
+// Largest file accepted by the media endpoint and the largest image it will decode.
+var MaxMediaSize = 10 << 20
+var maxMediaPixels = 40000000
+
+// Thumbnail names and the longest side in pixels of each.
+var thumbnailSizes = map[string]int{
+	"small":  150,
+	"medium": 480,
+	"large":  1080,
+}
+
+// Image types accepted, by sniffed content type, and the extension they are stored with.
+var mediaTypes = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+var errUnsupportedMedia = errors.New("unsupported media type")
+var errMediaTooLarge = errors.New("media too large")
+
+func mediaDirFromEnv() string {
+	if dir := os.Getenv("MEDIA_DIR"); dir != "" {
+		return dir
+	}
+	return "backend/pkg/media"
+}
+
+// Upload an image. POST /api/media with the file in the "file" multipart field.
+func MediaApi(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	user := LoggedInUser(r).Nickname
+	if user == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(JsonMessage("unauthorized"))
+		return
+	}
+
+	// Leave some room for the rest of the multipart body.
+	r.Body = http.MaxBytesReader(w, r.Body, int64(MaxMediaSize)+1<<20)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Please attach a file"))
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, int64(MaxMediaSize)+1))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(JsonMessage("Could not read file"))
+		return
+	}
+
+	media, err := StoreMedia(data, user)
+	if err == errMediaTooLarge {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write(JsonMessage("File is too large"))
+		return
+	} else if err == errUnsupportedMedia {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		w.Write(JsonMessage("Only jpeg, png and gif images are supported"))
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(JsonMessage("Could not store file, please try again later"))
+		return
+	}
+	content, _ := json.Marshal(media)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// Serve a stored file. GET /media/<id> or /media/<id>?size=small|medium|large
+func ServeMedia(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/media/")
+	media := GetMedia(id)
+	if media.Id == "" {
+		http.NotFound(w, r)
+		return
+	}
+	size := r.URL.Query().Get("size")
+	if _, ok := media.Thumbnails[size]; !ok {
+		size = ""
+	}
+	w.Header().Set("Content-Type", media.Mime)
+	// Content addressed files never change.
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeFile(w, r, mediaPath(media.Id, size, mediaTypes[media.Mime]))
+}
+
+// Check, clean and store an image, returning its media row. The file is stored under the
+// hash of its cleaned content so uploading the same image twice stores it once.
+func StoreMedia(data []byte, owner string) (MediaFields, error) {
+	if len(data) > MaxMediaSize {
+		return MediaFields{}, errMediaTooLarge
+	}
+	mime := http.DetectContentType(data)
+	extension, ok := mediaTypes[mime]
+	if !ok {
+		return MediaFields{}, errUnsupportedMedia
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width*config.Height > maxMediaPixels {
+		return MediaFields{}, errUnsupportedMedia
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return MediaFields{}, errUnsupportedMedia
+	}
+
+	// Re-encoding drops EXIF and any other metadata. Gifs carry no EXIF and
+	// are kept as they are so animations survive.
+	cleaned := data
+	if mime != "image/gif" {
+		cleaned, err = encodeImage(img, mime)
+		if err != nil {
+			return MediaFields{}, err
+		}
+	}
+	hash := sha256.Sum256(cleaned)
+	id := hex.EncodeToString(hash[:])
+	if existing := GetMedia(id); existing.Id != "" {
+		return existing, nil
+	}
+
+	if err := writeMediaFile(mediaPath(id, "", extension), cleaned); err != nil {
+		return MediaFields{}, err
+	}
+	var thumbnails []string
+	bounds := img.Bounds()
+	for name, maxSide := range thumbnailSizes {
+		// Thumbnails larger than the original just point at the original.
+		if bounds.Dx() <= maxSide && bounds.Dy() <= maxSide {
+			continue
+		}
+		thumbnail, err := encodeImage(resizeImage(img, maxSide), mime)
+		if err != nil {
+			return MediaFields{}, err
+		}
+		if err := writeMediaFile(mediaPath(id, name, extension), thumbnail); err != nil {
+			return MediaFields{}, err
+		}
+		thumbnails = append(thumbnails, name)
+	}
+
+	db := OpenDB()
+	defer db.Close()
+	_, err = db.Exec("INSERT OR IGNORE INTO media (id, owner, mime, size, width, height, thumbnails, created) values (?, ?, ?, ?, ?, ?, ?, strftime('%s','now') * 1000)",
+		id, owner, mime, len(cleaned), bounds.Dx(), bounds.Dy(), strings.Join(thumbnails, ","))
+	if err != nil {
+		fmt.Println("error adding media to table", err)
+		return MediaFields{}, err
+	}
+	return GetMedia(id), nil
+}
+
+func GetMedia(id string) MediaFields {
+	db := OpenDB()
+	defer db.Close()
+	var media MediaFields
+	var thumbnails string
+	err := db.QueryRow("SELECT id, owner, mime, size, width, height, thumbnails FROM media WHERE id = ?", id).
+		Scan(&media.Id, &media.Owner, &media.Mime, &media.Size, &media.Width, &media.Height, &thumbnails)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			fmt.Println("error getting media", err)
+		}
+		return MediaFields{}
+	}
+	media.Url = MediaUrl(media.Id)
+	media.Thumbnails = make(map[string]string)
+	for name := range thumbnailSizes {
+		// Sizes without their own file are served from the original.
+		media.Thumbnails[name] = media.Url
+		if Contains(strings.Split(thumbnails, ","), name) {
+			media.Thumbnails[name] = media.Url + "?size=" + name
+		}
+	}
+	return media
+}
+
+// Url posts, comments, messages and avatars store to reference a media file.
+func MediaUrl(id string) string {
+	return "/media/" + id
+}
+
+// Files are spread over sub directories named after the first two characters of the id.
+func mediaPath(id, size, extension string) string {
+	name := id
+	if size != "" {
+		name += "_" + size
+	}
+	return filepath.Join(MediaDir, id[:2], name+"."+extension)
+}
+
+func writeMediaFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		fmt.Println("error creating media directory", err)
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		fmt.Println("error writing media file", err)
+		return err
+	}
+	return nil
+}
+
+func encodeImage(img image.Image, mime string) ([]byte, error) {
+	var buffer bytes.Buffer
+	var err error
+	switch mime {
+	case "image/jpeg":
+		err = jpeg.Encode(&buffer, img, &jpeg.Options{Quality: 85})
+	case "image/png":
+		err = png.Encode(&buffer, img)
+	case "image/gif":
+		err = gif.Encode(&buffer, img, nil)
+	default:
+		err = errUnsupportedMedia
+	}
+	return buffer.Bytes(), err
+}
+
+// Scale an image down so its longest side is maxSide, averaging the source pixels
+// that fall into each destination pixel.
+func resizeImage(src image.Image, maxSide int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width >= height {
+		height = maxInt(1, height*maxSide/width)
+		width = maxSide
+	} else {
+		width = maxInt(1, width*maxSide/height)
+		height = maxSide
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := maxInt(y0+1, bounds.Min.Y+(y+1)*bounds.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := maxInt(x0+1, bounds.Min.X+(x+1)*bounds.Dx()/width)
+			var r, g, b, a, count uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					count++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / count), uint16(g / count), uint16(b / count), uint16(a / count)})
+		}
+	}
+	return dst
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Columns holding images, with the key of their table and the column of the image's owner.
+var mediaColumns = []struct{ table, column, key, owner string }{
+	{"users", "avatar", "id", "nickname"},
+	{"posts", "image", "id", "author"},
+	{"comments", "image", "id", "author"},
+	{"groupposts", "image", "postid", "author"},
+	{"groupComments", "image", "id", "author"},
+	{"groups", "avatar", "id", "admin"},
+	{"chatroom", "avatar", "id", "admin"},
+	{"drafts", "image", "id", "author"},
+	{"stories", "image", "id", "author"},
+}
+
+// Remove a media file, its thumbnails and its row once no image column references its url.
+// Files are stored by content, so the same image may still be used by another post or avatar.
+func RemoveUnusedMedia(url string) {
+	id := strings.TrimPrefix(url, MediaUrl(""))
+	if id == url {
+		return
+	}
+	media := GetMedia(id)
+	if media.Id == "" {
+		return
+	}
+	db := OpenDB()
+	defer db.Close()
+	for _, c := range mediaColumns {
+		var uses int
+		db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %v WHERE %v = ?", c.table, c.column), url).Scan(&uses)
+		if uses > 0 {
+			return
+		}
+	}
+	_, err := db.Exec("DELETE FROM media WHERE id = ?", id)
+	if err != nil {
+		fmt.Println("error removing media", err)
+		return
+	}
+	extension := mediaTypes[media.Mime]
+	os.Remove(mediaPath(id, "", extension))
+	for size := range thumbnailSizes {
+		os.Remove(mediaPath(id, size, extension))
+	}
+}
+
+// Move images stored as data urls in the database into the media store, replacing
+// each with its media url. Runs on startup and does nothing once every row is migrated.
+func MigrateDataUrls() {
+	db := OpenDB()
+	defer db.Close()
+	for _, c := range mediaColumns {
+		rows, err := db.Query(fmt.Sprintf("SELECT %v, %v, %v FROM %v WHERE %v LIKE 'data:%%'", c.key, c.owner, c.column, c.table, c.column))
+		if err != nil {
+			fmt.Println("error finding data urls in", c.table, err)
+			continue
+		}
+		migrated := make(map[string]string)
+		var key, owner, dataUrl string
+		for rows.Next() {
+			rows.Scan(&key, &owner, &dataUrl)
+			data, err := decodeDataUrl(dataUrl)
+			if err != nil {
+				fmt.Println("skipping invalid data url in", c.table, key, err)
+				continue
+			}
+			media, err := StoreMedia(data, owner)
+			if err != nil {
+				fmt.Println("skipping data url in", c.table, key, err)
+				continue
+			}
+			migrated[key] = media.Url
+		}
+		rows.Close()
+		for key, url := range migrated {
+			_, err := db.Exec(fmt.Sprintf("UPDATE %v SET %v = ? WHERE %v = ?", c.table, c.column, c.key), url, key)
+			if err != nil {
+				fmt.Println("error replacing data url in", c.table, key, err)
+			}
+		}
+		if len(migrated) > 0 {
+			fmt.Println("migrated", len(migrated), "data urls from", c.table)
+		}
+	}
+}
+
+// Report whether user may attach an image: data urls are stored as the user's own upload,
+// media urls only when the user uploaded the media. Anything else is refused.
+func OwnsImage(value, user string) bool {
+	if strings.HasPrefix(value, "data:") {
+		return true
+	}
+	id := strings.TrimPrefix(value, MediaUrl(""))
+	return id != value && GetMedia(id).Owner == user
+}
+
+// Store an image sent as a data url and return its media url. Anything else,
+// like an existing media url or a link, is returned unchanged.
+func StoreDataUrl(value, owner string) string {
+	if !strings.HasPrefix(value, "data:") {
+		return value
+	}
+	data, err := decodeDataUrl(value)
+	if err != nil {
+		return value
+	}
+	media, err := StoreMedia(data, owner)
+	if err != nil {
+		fmt.Println("error storing data url", err)
+		return value
+	}
+	return media.Url
+}
+
+func decodeDataUrl(dataUrl string) ([]byte, error) {
+	comma := strings.Index(dataUrl, ",")
+	if comma == -1 || !strings.HasSuffix(dataUrl[:comma], ";base64") {
+		return nil, errors.New("not a base64 data url")
+	}
+	return base64.StdEncoding.DecodeString(dataUrl[comma+1:])
+}
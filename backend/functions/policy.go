@@ -0,0 +1,96 @@
+package functions
+
+import "strings"
+
+// Visibility rules for posts and group content. Every read and write path goes through
+// CanView or CanInteract so content a user may not see behaves as if it does not exist.
+// CanEditComment and CanDeleteComment decide who may change a comment once it can be seen.
+
+// CanView reports whether user may see resource. Resources are PostFields, GroupPostFields,
+// GroupFields, GroupEventFields and StoryFields; anything else, or a resource that was not found, is hidden.
+func CanView(user string, resource interface{}) bool {
+	switch res := resource.(type) {
+	case PostFields:
+		if res.Id == "" {
+			return false
+		}
+		// Reposts never widen the audience of the original: its visibility applies as well.
+		if res.Original != nil && !res.OriginalDeleted && res.Original.Id == "" {
+			return false
+		}
+		if res.Author == user {
+			return true
+		}
+		switch res.Privacy {
+		case "public":
+			return true
+		case "private":
+			return user != "" && Contains(GetFollowing(GetUserByNickname(user)), res.Author)
+		case "almost-private":
+			return user != "" && Contains(strings.Split(res.Viewers, ","), user)
+		case "audience":
+			// Viewers holds the audience id, membership is checked now so list changes apply to past posts.
+			return user != "" && IsAudienceMember(res.Viewers, user)
+		}
+		return false
+	case GroupPostFields:
+		return res.PostId != "" && IsGroupMember(user, res.Id)
+	case GroupFields:
+		return res.Id != "" && IsGroupMember(user, res.Id)
+	case GroupEventFields:
+		return res.EventId != "" && IsGroupMember(user, res.GroupId)
+	case StoryFields:
+		// Stories follow the author's account like posts do: anyone logged in unless the account is private,
+		// then only followers.
+		if res.Id == "" || user == "" {
+			return false
+		}
+		if res.Author == user {
+			return true
+		}
+		author := GetUserByNickname(res.Author)
+		return author.Status != "private" || Contains(GetFollowing(GetUserByNickname(user)), res.Author)
+	}
+	return false
+}
+
+// CanInteract reports whether user may like, comment on or otherwise change resource.
+// Only logged in users who can see the resource may interact with it.
+func CanInteract(user string, resource interface{}) bool {
+	return user != "" && CanView(user, resource)
+}
+
+// IsGroupMember reports whether user is a member or the admin of the group.
+func IsGroupMember(user, groupId string) bool {
+	if user == "" || groupId == "" {
+		return false
+	}
+	group := GetGroup(groupId)
+	return group.Admin == user || Contains(strings.Split(group.Users, ","), user)
+}
+
+// CanEditComment reports whether user may edit a comment or group comment: only its author can.
+func CanEditComment(user string, comment CommentFields) bool {
+	return user != "" && comment.CommentId != "" && comment.Author == user
+}
+
+// CanDeleteComment reports whether user may delete a comment of targetType ("comment" or
+// "group-comment"). Besides its author, the author of the post and the admin of the group
+// can remove comments left on their content.
+func CanDeleteComment(user, targetType string, comment CommentFields) bool {
+	if CanEditComment(user, comment) {
+		return true
+	}
+	if user == "" || comment.CommentId == "" {
+		return false
+	}
+	db := OpenDB()
+	defer db.Close()
+	var postAuthor, groupId string
+	if targetType == "group-comment" {
+		db.QueryRow("SELECT author, id FROM groupposts WHERE postid = ?", comment.PostId).Scan(&postAuthor, &groupId)
+	} else {
+		db.QueryRow("SELECT author FROM posts WHERE id = ?", comment.PostId).Scan(&postAuthor)
+	}
+	return postAuthor == user || (groupId != "" && GetGroup(groupId).Admin == user)
+}
@@ -0,0 +1,222 @@
+package functions
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Tests run against a fresh database in a temporary directory, as OpenDB uses a path
+// relative to the working directory.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "social-network")
+	if err != nil {
+		panic(err)
+	}
+	os.MkdirAll(filepath.Join(dir, "backend", "pkg", "db", "sqlite"), 0o755)
+	os.Chdir(dir)
+	CreateSqlTables()
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// Add users with the given account status, and make follower follow each followee.
+func addPolicyUsers(t *testing.T, status map[string]string, follows [][2]string) {
+	t.Helper()
+	db := OpenDB()
+	defer db.Close()
+	for name, s := range status {
+		_, err := db.Exec("INSERT INTO users (email, password, firstname, lastname, dob, avatar, nickname, aboutme, status) values (?,?,?,?,?,?,?,?,?)", name+"@test", "", name, name, "", "", name, "", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range follows {
+		if _, err := db.Exec("INSERT INTO followers (follower, followee) values (?,?)", f[0]+"@test", f[1]+"@test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCanViewPublicPost(t *testing.T) {
+	post := PostFields{Id: "public-post", Author: "pub-author", Privacy: "public"}
+	tests := []struct {
+		name string
+		user string
+		want bool
+	}{
+		{"author", "pub-author", true},
+		{"other user", "pub-other", true},
+		{"anonymous", "", true},
+	}
+	for _, tt := range tests {
+		if got := CanView(tt.user, post); got != tt.want {
+			t.Errorf("%v: CanView = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCanViewPrivatePost(t *testing.T) {
+	addPolicyUsers(t, map[string]string{"priv-author": "private", "priv-follower": "public", "priv-stranger": "public"},
+		[][2]string{{"priv-follower", "priv-author"}, {"priv-author", "priv-stranger"}})
+	post := PostFields{Id: "private-post", Author: "priv-author", Privacy: "private"}
+	tests := []struct {
+		name string
+		user string
+		want bool
+	}{
+		{"author", "priv-author", true},
+		{"follower", "priv-follower", true},
+		{"followed by the author", "priv-stranger", false},
+		{"anonymous", "", false},
+	}
+	for _, tt := range tests {
+		if got := CanView(tt.user, post); got != tt.want {
+			t.Errorf("%v: CanView = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCanViewAlmostPrivatePost(t *testing.T) {
+	post := PostFields{Id: "almost-private-post", Author: "ap-author", Privacy: "almost-private", Viewers: "ap-viewer,ap-second"}
+	tests := []struct {
+		name string
+		user string
+		want bool
+	}{
+		{"author", "ap-author", true},
+		{"first viewer", "ap-viewer", true},
+		{"second viewer", "ap-second", true},
+		{"not a viewer", "ap-other", false},
+		{"viewer name prefix", "ap-view", false},
+		{"anonymous", "", false},
+	}
+	for _, tt := range tests {
+		if got := CanView(tt.user, post); got != tt.want {
+			t.Errorf("%v: CanView = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCanViewAudiencePost(t *testing.T) {
+	audience, err := SaveAudience(AudienceFields{Owner: "aud-author", Name: "close friends", Members: []string{"aud-member"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := PostFields{Id: "audience-post", Author: "aud-author", Privacy: "audience", Viewers: audience.Id}
+	tests := []struct {
+		name string
+		user string
+		want bool
+	}{
+		{"author", "aud-author", true},
+		{"member", "aud-member", true},
+		{"non-member", "aud-other", false},
+		{"anonymous", "", false},
+	}
+	for _, tt := range tests {
+		if got := CanView(tt.user, post); got != tt.want {
+			t.Errorf("%v: CanView = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Membership is checked when viewing, so removed members lose access to past posts.
+	audience.Members = nil
+	if _, err := SaveAudience(audience); err != nil {
+		t.Fatal(err)
+	}
+	if CanView("aud-member", post) {
+		t.Error("removed member: CanView = true, want false")
+	}
+}
+
+func TestCanViewGroupContent(t *testing.T) {
+	if err := AddGroup(GroupFields{Id: "policy-group", Name: "policy"}, "grp-admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddUserToGroup("policy-group", "grp-member"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		user     string
+		resource interface{}
+		want     bool
+	}{
+		{"admin sees group", "grp-admin", GroupFields{Id: "policy-group"}, true},
+		{"member sees group", "grp-member", GroupFields{Id: "policy-group"}, true},
+		{"non-member group", "grp-other", GroupFields{Id: "policy-group"}, false},
+		{"member sees post", "grp-member", GroupPostFields{Id: "policy-group", PostId: "group-post"}, true},
+		{"non-member post", "grp-other", GroupPostFields{Id: "policy-group", PostId: "group-post"}, false},
+		{"member sees event", "grp-member", GroupEventFields{GroupId: "policy-group", EventId: "event"}, true},
+		{"non-member event", "grp-other", GroupEventFields{GroupId: "policy-group", EventId: "event"}, false},
+		{"anonymous post", "", GroupPostFields{Id: "policy-group", PostId: "group-post"}, false},
+	}
+	for _, tt := range tests {
+		if got := CanView(tt.user, tt.resource); got != tt.want {
+			t.Errorf("%v: CanView = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCanViewStory(t *testing.T) {
+	addPolicyUsers(t, map[string]string{"story-public": "public", "story-unset": "", "story-private": "private", "story-follower": "public", "story-other": "public"},
+		[][2]string{{"story-follower", "story-private"}})
+	tests := []struct {
+		name   string
+		user   string
+		author string
+		want   bool
+	}{
+		{"public account", "story-other", "story-public", true},
+		{"account without a status", "story-other", "story-unset", true},
+		{"private account, author", "story-private", "story-private", true},
+		{"private account, follower", "story-follower", "story-private", true},
+		{"private account, non-follower", "story-other", "story-private", false},
+		{"anonymous", "", "story-public", false},
+	}
+	for _, tt := range tests {
+		if got := CanView(tt.user, StoryFields{Id: "story", Author: tt.author}); got != tt.want {
+			t.Errorf("%v: CanView = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCanViewMissingResource(t *testing.T) {
+	tests := []struct {
+		name     string
+		resource interface{}
+	}{
+		{"post", PostFields{}},
+		{"repost of a missing original", PostFields{Id: "repost", Author: "someone", Privacy: "public", Original: &PostFields{}}},
+		{"group post", GroupPostFields{Id: "policy-group"}},
+		{"group", GroupFields{}},
+		{"group event", GroupEventFields{GroupId: "policy-group"}},
+		{"story", StoryFields{Author: "story-public"}},
+		{"unknown type", "post"},
+		{"nil", nil},
+	}
+	for _, tt := range tests {
+		if CanView("someone", tt.resource) {
+			t.Errorf("%v: CanView = true, want false", tt.name)
+		}
+	}
+}
+
+func TestCanInteract(t *testing.T) {
+	post := PostFields{Id: "interact-post", Author: "int-author", Privacy: "public"}
+	tests := []struct {
+		name string
+		user string
+		want bool
+	}{
+		{"logged in", "int-other", true},
+		{"anonymous", "", false},
+	}
+	for _, tt := range tests {
+		if got := CanInteract(tt.user, post); got != tt.want {
+			t.Errorf("%v: CanInteract = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Link previews for posts, group posts and chat messages. The first link in the text is
+// fetched in the background once the content is saved, and previews are cached by URL.
+
+// PreviewFetcher builds the preview of a link. The default one reads the OpenGraph and
+// Twitter meta tags of the page over HTTP; tests can replace it with one that allows the
+// private address of a local server.
+type PreviewFetcher interface {
+	Fetch(ctx context.Context, link string) (LinkPreview, error)
+}
+
+var Previews PreviewFetcher = NewHTTPPreviewFetcher(false)
+
+// Most of a page read for its meta tags, longest a fetch may take and how long a
+// cached preview is used before the page is fetched again.
+var MaxPreviewSize int64 = 1 << 20
+var PreviewTimeout = 5 * time.Second
+var PreviewMaxAge = 24 * time.Hour
+
+var errPrivateAddress = errors.New("private address")
+var errNotHtml = errors.New("not an html page")
+
+var (
+	linkPattern  = regexp.MustCompile(`https?://[^\s<>"']+`)
+	metaPattern  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attrPattern  = regexp.MustCompile(`(?is)([a-z:_-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+// Carrier grade NAT addresses, not covered by net.IP.IsPrivate.
+var _, sharedAddressSpace, _ = net.ParseCIDR("100.64.0.0/10")
+
+// Links being fetched, so a link posted many times at once is fetched once.
+var previewFetches = struct {
+	sync.Mutex
+	links map[string]bool
+}{links: map[string]bool{}}
+
+type httpPreviewFetcher struct {
+	client *http.Client
+}
+
+// NewHTTPPreviewFetcher returns a fetcher reading pages over HTTP. Unless allowPrivate is set it
+// refuses to connect to private, loopback and link local addresses. The address is checked when
+// connecting, after DNS resolution, so redirects and names pointing inside the network are refused too.
+func NewHTTPPreviewFetcher(allowPrivate bool) PreviewFetcher {
+	if allowPrivate {
+		return newHTTPPreviewFetcher(nil)
+	}
+	return newHTTPPreviewFetcher(func(address string) bool {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return true
+		}
+		ip := net.ParseIP(host)
+		return ip == nil || isPrivateIP(ip)
+	})
+}
+
+// A fetcher refusing to connect to the resolved addresses (host:port) refuse reports, none when it is nil.
+func newHTTPPreviewFetcher(refuse func(address string) bool) PreviewFetcher {
+	dialer := &net.Dialer{Timeout: PreviewTimeout}
+	if refuse != nil {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			if refuse(address) {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+	return &httpPreviewFetcher{client: &http.Client{
+		Timeout: PreviewTimeout,
+		// No proxy: a proxy would connect on our behalf and skip the address check.
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   PreviewTimeout,
+			ResponseHeaderTimeout: PreviewTimeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
+	}}
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip)
+}
+
+func (f *httpPreviewFetcher) Fetch(ctx context.Context, link string) (LinkPreview, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", link, nil)
+	if err != nil {
+		return LinkPreview{}, err
+	}
+	req.Header.Set("Accept", "text/html")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return LinkPreview{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return LinkPreview{}, fmt.Errorf("preview of %v: status %v", link, resp.StatusCode)
+	}
+	if !strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		return LinkPreview{}, errNotHtml
+	}
+	// Meta tags are at the top of the page, a page cut at the limit still has them.
+	page, err := io.ReadAll(io.LimitReader(resp.Body, MaxPreviewSize))
+	if err != nil {
+		return LinkPreview{}, err
+	}
+	preview := ParsePreview(string(page), resp.Request.URL)
+	preview.Url = link
+	return preview, nil
+}
+
+// Build a preview from the OpenGraph and Twitter meta tags of a page, falling back
+// to its title and description. Relative image links are resolved against base.
+func ParsePreview(page string, base *url.URL) LinkPreview {
+	meta := map[string]string{}
+	for _, tag := range metaPattern.FindAllString(page, -1) {
+		attrs := map[string]string{}
+		for _, attr := range attrPattern.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(attr[1])] = attr[2] + attr[3] + attr[4]
+		}
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+		key = strings.ToLower(key)
+		if _, seen := meta[key]; key != "" && !seen {
+			meta[key] = strings.TrimSpace(html.UnescapeString(attrs["content"]))
+		}
+	}
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if meta[key] != "" {
+				return meta[key]
+			}
+		}
+		return ""
+	}
+
+	preview := LinkPreview{
+		Title:       first("og:title", "twitter:title"),
+		Description: first("og:description", "twitter:description", "description"),
+		SiteName:    first("og:site_name"),
+	}
+	if preview.Title == "" {
+		if title := titlePattern.FindStringSubmatch(page); title != nil {
+			preview.Title = strings.TrimSpace(html.UnescapeString(title[1]))
+		}
+	}
+	if preview.SiteName == "" && base != nil {
+		preview.SiteName = base.Hostname()
+	}
+	if image := first("og:image", "og:image:url", "og:image:secure_url", "twitter:image", "twitter:image:src"); image != "" && base != nil {
+		if imageUrl, err := base.Parse(image); err == nil && (imageUrl.Scheme == "http" || imageUrl.Scheme == "https") {
+			preview.Image = imageUrl.String()
+		}
+	}
+	preview.Title = truncateRunes(preview.Title, 300)
+	preview.Description = truncateRunes(preview.Description, 1000)
+	return preview
+}
+
+func truncateRunes(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max])
+}
+
+// The first http or https link in text, "" if there is none.
+func FirstLink(text string) string {
+	link := strings.TrimRight(linkPattern.FindString(text), ".,;:!?)]}")
+	if parsed, err := url.Parse(link); link == "" || err != nil || parsed.Hostname() == "" {
+		return ""
+	}
+	return link
+}
+
+// Attach the first link in text to an item and fetch its preview in the background.
+// Text without a link removes the item's preview.
+func QueueLinkPreview(targetType, targetId, text string) {
+	link := FirstLink(text)
+	db := OpenDB()
+	defer db.Close()
+	var err error
+	if link == "" {
+		_, err = db.Exec("DELETE FROM previewLinks WHERE targetType = ? AND targetId = ?", targetType, targetId)
+	} else {
+		_, err = db.Exec("INSERT OR REPLACE INTO previewLinks (targetType, targetId, url) VALUES (?, ?, ?)", targetType, targetId, link)
+	}
+	if err != nil {
+		fmt.Println("error saving preview link", err)
+		return
+	}
+	if link != "" {
+		go FetchLinkPreview(link)
+	}
+}
+
+// Fetch and cache the preview of a link unless a recent one is cached. Failed fetches are
+// cached as well, so a broken link is not fetched again for every post that shares it.
+func FetchLinkPreview(link string) {
+	db := OpenDB()
+	var fetched int64
+	db.QueryRow("SELECT fetched FROM linkPreviews WHERE url = ?", link).Scan(&fetched)
+	db.Close()
+	if time.Since(time.UnixMilli(fetched)) < PreviewMaxAge {
+		return
+	}
+	previewFetches.Lock()
+	if previewFetches.links[link] {
+		previewFetches.Unlock()
+		return
+	}
+	previewFetches.links[link] = true
+	previewFetches.Unlock()
+	defer func() {
+		previewFetches.Lock()
+		delete(previewFetches.links, link)
+		previewFetches.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), PreviewTimeout)
+	defer cancel()
+	preview, err := Previews.Fetch(ctx, link)
+	if err != nil {
+		fmt.Println("error fetching link preview", err)
+		preview = LinkPreview{}
+	}
+	db = OpenDB()
+	defer db.Close()
+	_, err = db.Exec("INSERT OR REPLACE INTO linkPreviews (url, title, description, image, siteName, fetched, failed) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		link, preview.Title, preview.Description, preview.Image, preview.SiteName, time.Now().UnixMilli(), err != nil)
+	if err != nil {
+		fmt.Println("error caching link preview", err)
+	}
+}
+
+// Get the preview card of a post, group post or chat message, nil until one was fetched.
+func GetLinkPreview(targetType, targetId string) *LinkPreview {
+	db := OpenDB()
+	defer db.Close()
+	var preview LinkPreview
+	err := db.QueryRow(`SELECT p.url, p.title, p.description, p.image, p.siteName FROM previewLinks l
+		JOIN linkPreviews p ON p.url = l.url WHERE l.targetType = ? AND l.targetId = ? AND p.failed = 0`, targetType, targetId).
+		Scan(&preview.Url, &preview.Title, &preview.Description, &preview.Image, &preview.SiteName)
+	if err != nil || (preview.Title == "" && preview.Description == "" && preview.Image == "") {
+		return nil
+	}
+	return &preview
+}
+
+func RemoveLinkPreview(targetType, targetId string) {
+	db := OpenDB()
+	defer db.Close()
+	_, err := db.Exec("DELETE FROM previewLinks WHERE targetType = ? AND targetId = ?", targetType, targetId)
+	if err != nil {
+		fmt.Println("error removing preview link", err)
+	}
+}
@@ -0,0 +1,197 @@
+package functions
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePreview(t *testing.T) {
+	base, _ := url.Parse("https://example.com/articles/1")
+	tests := []struct {
+		name string
+		page string
+		want LinkPreview
+	}{
+		{
+			"open graph",
+			`<head><meta property="og:title" content="OG title"><meta property="og:description" content="OG &amp; more">
+			<meta property="og:image" content="/img/cover.png"><meta property="og:site_name" content="Example"></head>`,
+			LinkPreview{Title: "OG title", Description: "OG & more", Image: "https://example.com/img/cover.png", SiteName: "Example"},
+		},
+		{
+			"twitter fallback",
+			`<meta name="twitter:title" content='Tweet title'><meta name="twitter:description" content="Tweet text">
+			<meta name="twitter:image" content="https://cdn.example.com/t.jpg">`,
+			LinkPreview{Title: "Tweet title", Description: "Tweet text", Image: "https://cdn.example.com/t.jpg", SiteName: "example.com"},
+		},
+		{
+			"title and description",
+			`<title> Plain page </title><meta name="description" content="About the page">`,
+			LinkPreview{Title: "Plain page", Description: "About the page", SiteName: "example.com"},
+		},
+		{
+			"open graph before twitter",
+			`<meta name="twitter:title" content="Tweet title"><meta property="og:title" content="OG title">`,
+			LinkPreview{Title: "OG title", SiteName: "example.com"},
+		},
+		{
+			"first tag wins",
+			`<meta property="og:title" content="First"><meta property="og:title" content="Second">`,
+			LinkPreview{Title: "First", SiteName: "example.com"},
+		},
+		{
+			"image that is not http",
+			`<meta property="og:title" content="Title"><meta property="og:image" content="javascript:alert(1)">`,
+			LinkPreview{Title: "Title", SiteName: "example.com"},
+		},
+		{
+			"no tags",
+			`<p>nothing here</p>`,
+			LinkPreview{SiteName: "example.com"},
+		},
+	}
+	for _, tt := range tests {
+		if got := ParsePreview(tt.page, base); got != tt.want {
+			t.Errorf("%v: ParsePreview = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParsePreviewTruncates(t *testing.T) {
+	page := `<meta property="og:title" content="` + strings.Repeat("é", 500) + `">`
+	if got := ParsePreview(page, nil); len([]rune(got.Title)) != 300 {
+		t.Errorf("title of %v runes, want 300", len([]rune(got.Title)))
+	}
+}
+
+func TestFirstLink(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"see https://example.com/a?b=c for more", "https://example.com/a?b=c"},
+		{"two links http://one.example and https://two.example", "http://one.example"},
+		{"at the end of a sentence https://example.com/page.", "https://example.com/page"},
+		{"(in brackets https://example.com/x)", "https://example.com/x"},
+		{`<a href="https://example.com/quoted">`, "https://example.com/quoted"},
+		{"no scheme example.com", ""},
+		{"not a host https://", ""},
+		{"ftp://example.com/file", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := FirstLink(tt.text); got != tt.want {
+			t.Errorf("FirstLink(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func previewServer(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func htmlPage(page string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+	}
+}
+
+func TestFetchPreview(t *testing.T) {
+	server := previewServer(t, htmlPage(`<meta property="og:title" content="Local page"><meta property="og:image" content="/cover.png">`))
+	preview, err := NewHTTPPreviewFetcher(true).Fetch(context.Background(), server.URL+"/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := LinkPreview{Url: server.URL + "/page", Title: "Local page", Image: server.URL + "/cover.png", SiteName: "127.0.0.1"}
+	if preview != want {
+		t.Errorf("Fetch = %+v, want %+v", preview, want)
+	}
+}
+
+func TestFetchPreviewRefusesPrivateAddresses(t *testing.T) {
+	server := previewServer(t, htmlPage(`<title>Internal</title>`))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, link := range []string{server.URL, "http://10.0.0.1/", "http://[::1]:1/", "http://169.254.169.254/latest/meta-data/"} {
+		if _, err := NewHTTPPreviewFetcher(false).Fetch(ctx, link); !errors.Is(err, errPrivateAddress) {
+			t.Errorf("Fetch(%v) error = %v, want %v", link, err, errPrivateAddress)
+		}
+	}
+}
+
+func TestFetchPreviewRefusesRedirectToPrivateAddress(t *testing.T) {
+	internal := previewServer(t, htmlPage(`<title>Internal</title>`))
+	public := previewServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL, http.StatusFound)
+	})
+	// The public server stands in for an outside host: only the internal one is refused.
+	internalAddress := strings.TrimPrefix(internal.URL, "http://")
+	fetcher := newHTTPPreviewFetcher(func(address string) bool { return address == internalAddress })
+	if _, err := fetcher.Fetch(context.Background(), public.URL); !errors.Is(err, errPrivateAddress) {
+		t.Errorf("Fetch error = %v, want %v", err, errPrivateAddress)
+	}
+}
+
+func TestFetchPreviewSizeLimit(t *testing.T) {
+	defer func(size int64) { MaxPreviewSize = size }(MaxPreviewSize)
+	MaxPreviewSize = 1024
+	tag := `<meta property="og:title" content="Title">`
+	tests := []struct {
+		name    string
+		padding int
+		want    string
+	}{
+		{"tag within the limit", 100, "Title"},
+		{"tag past the limit", 2048, ""},
+	}
+	for _, tt := range tests {
+		server := previewServer(t, htmlPage(strings.Repeat(" ", tt.padding)+tag))
+		preview, err := NewHTTPPreviewFetcher(true).Fetch(context.Background(), server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if preview.Title != tt.want {
+			t.Errorf("%v: title = %q, want %q", tt.name, preview.Title, tt.want)
+		}
+	}
+}
+
+func TestFetchPreviewTimeLimit(t *testing.T) {
+	defer func(timeout time.Duration) { PreviewTimeout = timeout }(PreviewTimeout)
+	PreviewTimeout = 100 * time.Millisecond
+	release := make(chan struct{})
+	server := previewServer(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+		}
+	})
+	defer close(release)
+	start := time.Now()
+	if _, err := NewHTTPPreviewFetcher(true).Fetch(context.Background(), server.URL); err == nil {
+		t.Error("Fetch of a stalled page succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Fetch took %v, want about %v", elapsed, PreviewTimeout)
+	}
+}
+
+func TestFetchPreviewNotHtml(t *testing.T) {
+	server := previewServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	})
+	if _, err := NewHTTPPreviewFetcher(true).Fetch(context.Background(), server.URL); !errors.Is(err, errNotHtml) {
+		t.Errorf("Fetch error = %v, want %v", err, errNotHtml)
+	}
+}
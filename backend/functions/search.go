@@ -0,0 +1,320 @@
+package functions
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf16"
+)
+
+// Full text search over posts, comments, group posts, group comments and chat messages.
+// With a SQLite built with FTS5 (go build -tags sqlite_fts5) every table has an FTS5 index kept
+// in sync by triggers, so all write paths are covered. Without it search falls back to scanning
+// the tables with search_match, which matches words the same way and so finds the same content,
+// more slowly and without ranking. Both match each term as the prefix of a word, case folded,
+// with words split on anything but letters and numbers.
+
+// Types of content search covers.
+var SearchTypes = []string{"post", "comment", "group-post", "group-comment", "message"}
+
+// Set on startup when the FTS5 indexes are available.
+var searchIndexed bool
+
+const maxSearchTerms = 8
+
+// Characters kept either side of the first match in a snippet.
+const snippetRadius = 60
+
+type searchSource struct {
+	kind, table, alias, id, parent, author, text, time string
+}
+
+var searchSources = []searchSource{
+	{"post", "posts", "p", "id", "''", "author", "text", "time"},
+	{"comment", "comments", "c", "id", "c.postid", "author", "text", "time"},
+	{"group-post", "groupposts", "gp", "postid", "gp.id", "author", "text", "time"},
+	{"group-comment", "groupComments", "gc", "id", "gc.postid", "author", "text", "time"},
+	{"message", "messages", "m", "messageId", "m.id", "sender", "message", "date"},
+}
+
+// Create the FTS5 indexes and their triggers. The tables have text ids and their rowids change on
+// VACUUM, so each index has a key table giving every id a stable integer key to use as its rowid.
+// Indexes whose triggers are missing, because they are new or search was unavailable for a while,
+// are rebuilt from their table. Without FTS5 the triggers are dropped, as writes to the tables
+// would fail on them.
+func CreateSearchIndex(db *sql.DB) {
+	var fts5 bool
+	db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5)
+	for _, source := range searchSources {
+		index, keys := source.table+"_search", source.table+"_search_keys"
+		var schema string
+		db.QueryRow("SELECT IFNULL(sql, '') FROM sqlite_master WHERE name = ?", index).Scan(&schema)
+		// Indexes from before key tables used the table rowids, which VACUUM does not keep.
+		if !fts5 || strings.Contains(schema, "content_rowid") {
+			for _, trigger := range []string{"insert", "update", "delete"} {
+				db.Exec("DROP TRIGGER IF EXISTS `" + index + "_" + trigger + "`")
+			}
+		}
+		if !fts5 {
+			continue
+		}
+		if strings.Contains(schema, "content_rowid") {
+			var _, dropError = db.Exec("DROP TABLE `" + index + "`")
+			CheckErr(dropError, "-------Error dropping search index")
+		}
+		var _, keysError = db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%v` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `id` TEXT NOT NULL UNIQUE)", keys))
+		CheckErr(keysError, "-------Error creating search keys")
+		var _, indexError = db.Exec(fmt.Sprintf("CREATE VIRTUAL TABLE IF NOT EXISTS `%v` USING fts5(`%v`, tokenize = 'unicode61 remove_diacritics 0')", index, source.text))
+		CheckErr(indexError, "-------Error creating search index")
+		var triggers int
+		db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE ?", index+"_%").Scan(&triggers)
+		insert := fmt.Sprintf("INSERT OR IGNORE INTO `%v` (id) VALUES (new.`%v`); INSERT INTO `%v` (rowid, `%v`) SELECT key, new.`%v` FROM `%v` WHERE id = new.`%v`;",
+			keys, source.id, index, source.text, source.text, keys, source.id)
+		remove := fmt.Sprintf("DELETE FROM `%v` WHERE rowid = (SELECT key FROM `%v` WHERE id = old.`%v`);", index, keys, source.id)
+		for _, trigger := range []string{
+			fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS `%v_insert` AFTER INSERT ON `%v` BEGIN %v END", index, source.table, insert),
+			fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS `%v_update` AFTER UPDATE OF `%v` ON `%v` BEGIN %v %v END", index, source.text, source.table, remove, insert),
+			fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS `%v_delete` AFTER DELETE ON `%v` BEGIN %v DELETE FROM `%v` WHERE id = old.`%v`; END", index, source.table, remove, keys, source.id),
+		} {
+			var _, triggerError = db.Exec(trigger)
+			CheckErr(triggerError, "-------Error creating search trigger")
+		}
+		if triggers < 3 {
+			for _, rebuild := range []string{
+				fmt.Sprintf("DELETE FROM `%v`", index),
+				fmt.Sprintf("DELETE FROM `%v` WHERE id NOT IN (SELECT `%v` FROM `%v`)", keys, source.id, source.table),
+				fmt.Sprintf("INSERT OR IGNORE INTO `%v` (id) SELECT `%v` FROM `%v`", keys, source.id, source.table),
+				fmt.Sprintf("INSERT INTO `%v` (rowid, `%v`) SELECT k.key, t.`%v` FROM `%v` t JOIN `%v` k ON k.id = t.`%v`", index, source.text, source.text, source.table, keys, source.id),
+			} {
+				var _, rebuildError = db.Exec(rebuild)
+				CheckErr(rebuildError, "-------Error building search index")
+			}
+		}
+	}
+	searchIndexed = fts5
+	if !fts5 {
+		fmt.Println("full text search index unavailable, build with -tags sqlite_fts5 to enable it")
+	}
+}
+
+// Split a query into lower case words. Punctuation separates words and is dropped,
+// so terms are safe to put in an FTS5 query.
+func SearchTerms(query string) []string {
+	terms := textWords(query)
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+	return terms
+}
+
+// FTS5 query matching rows with every term at the start of a word.
+func searchIndexQuery(terms []string) string {
+	var match []string
+	for _, term := range terms {
+		match = append(match, `"`+term+`"*`)
+	}
+	return strings.Join(match, " ")
+}
+
+// Whether every term, separated by spaces, is the start of a word of text. This is how the FTS5
+// index matches a query, used in SQL as search_match when the index is unavailable.
+func SearchMatches(text, terms string) bool {
+	words := textWords(text)
+	for _, term := range strings.Fields(terms) {
+		found := false
+		for _, word := range words {
+			if strings.HasPrefix(word, term) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Search the content viewer can see for every term, best match first when the index is
+// available and newest first otherwise. Chat messages come only from the viewer's chat rooms
+// and group content only from the viewer's groups, and muted authors and words are left out.
+// Types, author and the time range are optional.
+func Search(viewer User, terms, types []string, author string, from, to int64, cursor string, limit int) (SearchPage, error) {
+	page := SearchPage{Results: []SearchResultFields{}}
+	offset := 0
+	if key, err := DecodeCursor(cursor); err != nil {
+		return page, err
+	} else if len(key) == 1 {
+		if offset, err = strconv.Atoi(key[0]); err != nil {
+			return page, err
+		}
+	}
+	if len(terms) == 0 {
+		return page, nil
+	}
+
+	now := time.Now().UnixMilli()
+	var branches []string
+	var args []interface{}
+	for _, source := range searchSources {
+		if len(types) > 0 && !Contains(types, source.kind) {
+			continue
+		}
+		a := source.alias
+		var branch string
+		if searchIndexed {
+			index := source.table + "_search"
+			branch = fmt.Sprintf("SELECT '%v' AS type, %v.%v, %v, %v.%v, IFNULL(%v.%v, ''), %v.%v AS time, bm25(`%v`) AS rank FROM `%v` JOIN `%v_keys` k ON k.key = `%v`.rowid JOIN `%v` %v ON %v.%v = k.id WHERE `%v` MATCH ?",
+				source.kind, a, source.id, source.parent, a, source.author, a, source.text, a, source.time, index, index, index, index, source.table, a, a, source.id, index)
+			args = append(args, searchIndexQuery(terms))
+		} else {
+			branch = fmt.Sprintf("SELECT '%v' AS type, %v.%v, %v, %v.%v, IFNULL(%v.%v, ''), %v.%v AS time, 0 AS rank FROM `%v` %v WHERE search_match(IFNULL(%v.%v, ''), ?)",
+				source.kind, a, source.id, source.parent, a, source.author, a, source.text, a, source.time, source.table, a, a, source.text)
+			args = append(args, strings.Join(terms, " "))
+		}
+		visible, visibleArgs := searchVisibility(source, viewer)
+		branch += " AND " + visible + " AND " + searchMuteFilter(source)
+		args = append(args, visibleArgs...)
+		args = append(args, viewer.Nickname, now)
+		if author != "" {
+			branch += fmt.Sprintf(" AND %v.%v = ?", a, source.author)
+			args = append(args, author)
+		}
+		if from != 0 {
+			branch += fmt.Sprintf(" AND %v.%v >= ?", a, source.time)
+			args = append(args, from)
+		}
+		if to != 0 {
+			branch += fmt.Sprintf(" AND %v.%v <= ?", a, source.time)
+			args = append(args, to)
+		}
+		branches = append(branches, branch)
+	}
+	if len(branches) == 0 {
+		return page, nil
+	}
+	args = append(args, limit+1, offset)
+
+	db := OpenDB()
+	defer db.Close()
+	rows, err := db.Query(strings.Join(branches, " UNION ALL ")+" ORDER BY rank, time DESC LIMIT ? OFFSET ?", args...)
+	if err != nil {
+		fmt.Println("error searching", err)
+		return page, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var result SearchResultFields
+		var text string
+		var rank float64
+		err := rows.Scan(&result.Type, &result.Id, &result.ParentId, &result.Author, &text, &result.Time, &rank)
+		if err != nil {
+			fmt.Println("error reading search result", err)
+			continue
+		}
+		if len(page.Results) == limit {
+			page.NextCursor = EncodeCursor(strconv.Itoa(offset + limit))
+			break
+		}
+		result.Snippet, result.Highlights = SearchSnippet(text, terms)
+		page.Results = append(page.Results, result)
+	}
+	return page, nil
+}
+
+// SQL condition limiting a search source to what viewer can see, with its arguments.
+func searchVisibility(source searchSource, viewer User) (string, []interface{}) {
+	inGroup := "(instr(',' || g.users || ',', ',' || ? || ',') > 0 OR g.admin = ?)"
+	switch source.kind {
+	case "post":
+		visible, args := visiblePostSQL("p", viewer)
+		original, originalArgs := visiblePostSQL("o", viewer)
+		// Reposts and quotes also need the original to be visible.
+		return visible + ` AND NOT EXISTS (SELECT 1 FROM reposts rp WHERE rp.postId = p.id AND rp.originalDeleted = 0
+			AND NOT EXISTS (SELECT 1 FROM posts o WHERE o.id = rp.originalId AND ` + original + `))`, append(args, originalArgs...)
+	case "comment":
+		visible, args := visiblePostSQL("vp", viewer)
+		return "EXISTS (SELECT 1 FROM posts vp WHERE vp.id = c.postid AND " + visible + ")", args
+	case "group-post":
+		return "EXISTS (SELECT 1 FROM groups g WHERE g.id = gp.id AND " + inGroup + ")", []interface{}{viewer.Nickname, viewer.Nickname}
+	case "group-comment":
+		return "EXISTS (SELECT 1 FROM groupposts vgp JOIN groups g ON g.id = vgp.id WHERE vgp.postid = gc.postid AND " + inGroup + ")", []interface{}{viewer.Nickname, viewer.Nickname}
+	case "message":
+		return "EXISTS (SELECT 1 FROM chatroom r WHERE r.id = m.id AND (instr(',' || r.users || ',', ',' || ? || ',') > 0 OR r.admin = ?))", []interface{}{viewer.Nickname, viewer.Nickname}
+	}
+	return "0", nil
+}
+
+// SQL condition leaving out what viewer muted, taking the viewer's nickname and the current time.
+// Chat messages have no hashtags, so only muted senders and words apply to them.
+func searchMuteFilter(source searchSource) string {
+	a := source.alias
+	if source.kind != "message" {
+		return MutedContentFilter(a)
+	}
+	return `NOT EXISTS (SELECT 1 FROM mutes mm WHERE mm.user = ? AND (mm.expires = 0 OR mm.expires > ?) AND (
+		(mm.type = 'user' AND mm.muted = ` + a + `.sender) OR
+		(mm.type = 'word' AND has_word(IFNULL(` + a + `.message, ''), mm.muted))))`
+}
+
+// Cut text around the first match of any term and return the highlighted ranges in the cut.
+// Offsets are in UTF-16 code units like mention spans, so clients can use them on JS strings.
+func SearchSnippet(text string, terms []string) (string, []TextSpan) {
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	type match struct{ start, end int }
+	var matches []match
+	for _, term := range terms {
+		termRunes := []rune(term)
+		for i := 0; i+len(termRunes) <= len(lower); i++ {
+			// Terms match the start of words, as they do in the search itself.
+			if i > 0 && isWordRune(lower[i-1]) {
+				continue
+			}
+			if string(lower[i:i+len(termRunes)]) == term {
+				matches = append(matches, match{i, i + len(termRunes)})
+			}
+		}
+	}
+	sort.Slice(matches, func(a, b int) bool { return matches[a].start < matches[b].start })
+
+	start, end := 0, len(runes)
+	if len(matches) > 0 && matches[0].start > snippetRadius {
+		start = matches[0].start - snippetRadius
+	}
+	if end-start > 3*snippetRadius {
+		end = start + 3*snippetRadius
+	}
+	prefix, suffix := "", ""
+	if start > 0 {
+		prefix = "…"
+	}
+	if end < len(runes) {
+		suffix = "…"
+	}
+	offset := len(utf16.Encode([]rune(prefix)))
+	spans := []TextSpan{}
+	for _, m := range matches {
+		if m.start < start || m.end > end {
+			continue
+		}
+		spanStart := offset + len(utf16.Encode(runes[start:m.start]))
+		spanEnd := offset + len(utf16.Encode(runes[start:m.end]))
+		// Overlapping matches of different terms are merged into one range.
+		if len(spans) > 0 && spanStart <= spans[len(spans)-1].End {
+			if spanEnd > spans[len(spans)-1].End {
+				spans[len(spans)-1].End = spanEnd
+			}
+			continue
+		}
+		spans = append(spans, TextSpan{Start: spanStart, End: spanEnd})
+	}
+	return prefix + string(runes[start:end]) + suffix, spans
+}
@@ -0,0 +1,106 @@
+package functions
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSearchSnippet(t *testing.T) {
+	emoji := strings.Repeat("😀", 100)
+	long := "cat " + strings.Repeat("x ", 100) + "cat"
+	tests := []struct {
+		name    string
+		text    string
+		terms   []string
+		snippet string
+		want    []TextSpan
+	}{
+		{"match", "hello world", []string{"world"}, "hello world", []TextSpan{{6, 11}}},
+		{"upper case text", "Hello World", []string{"world"}, "Hello World", []TextSpan{{6, 11}}},
+		{"emoji before the match", "😀 cat", []string{"cat"}, "😀 cat", []TextSpan{{3, 6}}},
+		{"emoji between matches", "😀 cat 😀 cat", []string{"cat"}, "😀 cat 😀 cat", []TextSpan{{3, 6}, {10, 13}}},
+		{"inside a word", "concat cat", []string{"cat"}, "concat cat", []TextSpan{{7, 10}}},
+		{"every match", "cat and cat", []string{"cat"}, "cat and cat", []TextSpan{{0, 3}, {8, 11}}},
+		{"several terms", "cat and dog", []string{"dog", "cat"}, "cat and dog", []TextSpan{{0, 3}, {8, 11}}},
+		{"overlapping terms", "category", []string{"cat", "categ"}, "category", []TextSpan{{0, 5}}},
+		{"no match", "hello world", []string{"cat"}, "hello world", []TextSpan{}},
+		// 41 runes are cut before the match: the prefix is one code unit and each emoji two.
+		{"cut before the match", emoji + " cat", []string{"cat"}, "…" + strings.Repeat("😀", 59) + " cat", []TextSpan{{120, 123}}},
+		{"match after the cut", long, []string{"cat"}, long[:3*snippetRadius] + "…", []TextSpan{{0, 3}}},
+	}
+	for _, tt := range tests {
+		snippet, spans := SearchSnippet(tt.text, tt.terms)
+		if snippet != tt.snippet || !reflect.DeepEqual(spans, tt.want) {
+			t.Errorf("%v: SearchSnippet = %q, %v, want %q, %v", tt.name, snippet, spans, tt.snippet, tt.want)
+		}
+	}
+}
+
+var searchMatchTests = []struct {
+	name  string
+	text  string
+	query string
+	want  bool
+}{
+	{"word", "the cat sat", "cat", true},
+	{"start of a word", "a new category", "cat", true},
+	{"inside a word", "concat the lists", "cat", false},
+	{"upper case", "THE CAT", "Cat", true},
+	{"every term", "cats and dogs", "dog cat", true},
+	{"a term missing", "cats and birds", "dog cat", false},
+	{"punctuation in the text", "(cat), dog!", "cat dog", true},
+	{"punctuation in the query", "send an e-mail", "e-mail", true},
+	{"hyphen dropped", "email me", "e-mail", false},
+	{"apostrophe", "don't stop", "don't", true},
+	{"hashtag", "love #golang", "#golang", true},
+	{"emoji", "party 🎉 time", "time", true},
+	{"accents kept", "un cafe", "café", false},
+	{"number", "chapter 12", "1", true},
+}
+
+// The fallback matches terms the way the search index does, with the same query terms.
+func TestSearchMatches(t *testing.T) {
+	db := OpenDB()
+	defer db.Close()
+	for _, tt := range searchMatchTests {
+		terms := strings.Join(SearchTerms(tt.query), " ")
+		if got := SearchMatches(tt.text, terms); got != tt.want {
+			t.Errorf("%v: SearchMatches(%q, %q) = %v, want %v", tt.name, tt.text, terms, got, tt.want)
+		}
+		var matched bool
+		if err := db.QueryRow("SELECT search_match(?, ?)", tt.text, terms).Scan(&matched); err != nil {
+			t.Fatal(err)
+		}
+		if matched != tt.want {
+			t.Errorf("%v: search_match(%q, %q) = %v, want %v", tt.name, tt.text, terms, matched, tt.want)
+		}
+	}
+}
+
+func TestSearchIndexMatchesFallback(t *testing.T) {
+	db := OpenDB()
+	defer db.Close()
+	var fts5 bool
+	db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5)
+	if !fts5 {
+		t.Skip("build with -tags sqlite_fts5 to test the search index")
+	}
+	if _, err := db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS temp.search_test USING fts5(text, tokenize = 'unicode61 remove_diacritics 0')"); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DROP TABLE temp.search_test")
+	for _, tt := range searchMatchTests {
+		db.Exec("DELETE FROM temp.search_test")
+		if _, err := db.Exec("INSERT INTO temp.search_test (text) VALUES (?)", tt.text); err != nil {
+			t.Fatal(err)
+		}
+		var found int
+		if err := db.QueryRow("SELECT COUNT(*) FROM temp.search_test WHERE search_test MATCH ?", searchIndexQuery(SearchTerms(tt.query))).Scan(&found); err != nil {
+			t.Fatal(err)
+		}
+		if (found == 1) != tt.want {
+			t.Errorf("%v: index match of %q in %q = %v, want %v", tt.name, tt.query, tt.text, found == 1, tt.want)
+		}
+	}
+}
@@ -39,11 +39,50 @@ type ChatroomType struct {
 }
 
 type ChatFields struct {
-	Id        string `json:"id"`
-	Sender    string `json:"sender"`
-	MessageId string `json:"message-id"`
-	Message   string `json:"message"`
-	Date      int    `json:"date"`
+	Id        string        `json:"id"`
+	Sender    string        `json:"sender"`
+	MessageId string        `json:"message-id"`
+	Message   string        `json:"message"`
+	Date      int           `json:"date"`
+	Mentions  []MentionSpan `json:"mentions,omitempty"`
+	Preview   *LinkPreview  `json:"link-preview,omitempty"`
+}
+
+type StoryFields struct {
+	Id        string `json:"story-id"`
+	Author    string `json:"author"`
+	AuthorImg string `json:"author-img"`
+	Image     string `json:"story-image"`
+	Text      string `json:"story-text"`
+	Created   int    `json:"story-time"`
+	Expires   int    `json:"expires-at"`
+	Seen      bool   `json:"seen"`
+	// Number of people who saw the story, only given to its author.
+	Views int    `json:"views"`
+	Error string `json:"error"`
+}
+
+// An author's current stories. Unseen counts the ones the viewer has not seen yet.
+type StoryGroupFields struct {
+	Author    string        `json:"author"`
+	AuthorImg string        `json:"author-img"`
+	Stories   []StoryFields `json:"stories"`
+	Unseen    int           `json:"unseen"`
+}
+
+type StoryViewFields struct {
+	Viewer    string `json:"viewer"`
+	ViewerImg string `json:"viewer-img"`
+	Time      int    `json:"time"`
+}
+
+// Preview card of the first link in a post, group post or chat message.
+type LinkPreview struct {
+	Url         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+	SiteName    string `json:"site-name"`
 }
 
 type Follow struct {
@@ -67,6 +106,18 @@ type followNotification struct {
 	FollowerFollowingCount int    `json:"followerFollowingCount"`
 }
 
+type presenceMessage struct {
+	User     string `json:"presence-user"`
+	Status   string `json:"presence"`
+	LastSeen int    `json:"last-seen"`
+	// Nicknames the presence change is pushed to.
+	audience []string
+}
+
+type PresenceSettings struct {
+	Hidden bool `json:"hide-presence"`
+}
+
 type RequestNotifcationFields struct {
 	FollowRequest followMessage           `json:"notification-followRequest"`
 	GroupRequest  GroupFields             `json:"notification-groupRequest"`
@@ -101,22 +152,69 @@ type OpenChatInfo struct {
 }
 
 type PostFields struct {
-	Id           string `json:"post-id"`
-	Author       string `json:"author"`
-	AuthorImg    string `json:"author-img"`
-	Image        string `json:"post-image"`
-	Text         string `json:"post-text-content"`
-	Thread       string `json:"post-threads"`
-	Likes        int    `json:"post-likes"`
-	PostLiked    bool   `json:"post-liked"`
-	Dislikes     int    `json:"post-dislikes"`
-	PostDisliked bool   `json:"post-disliked"`
-	PostComments int    `json:"post-comments"`
-	PostAuthor   bool   `json:"post-author"`
-	Time         int    `json:"post-time"`
-	Privacy      string `json:"privacy"`
-	Viewers      string `json:"viewers"`
-	Error        string `json:"error"`
+	Id           string         `json:"post-id"`
+	Author       string         `json:"author"`
+	AuthorImg    string         `json:"author-img"`
+	Image        string         `json:"post-image"`
+	Text         string         `json:"post-text-content"`
+	Thread       string         `json:"post-threads"`
+	Likes        int            `json:"post-likes"`
+	PostLiked    bool           `json:"post-liked"`
+	Dislikes     int            `json:"post-dislikes"`
+	PostDisliked bool           `json:"post-disliked"`
+	Reactions    map[string]int `json:"reactions"`
+	Reaction     string         `json:"my-reaction"`
+	PostComments int            `json:"post-comments"`
+	PostAuthor   bool           `json:"post-author"`
+	Time         int            `json:"post-time"`
+	Privacy      string         `json:"privacy"`
+	Viewers      string         `json:"viewers"`
+	Mentions     []MentionSpan  `json:"mentions,omitempty"`
+	Edited       bool           `json:"edited"`
+	EditedAt     int            `json:"edited-at"`
+	// Post, repost or quote. Reposts and quotes reference the original post.
+	Kind            string       `json:"post-kind"`
+	Original        *PostFields  `json:"original,omitempty"`
+	OriginalDeleted bool         `json:"original-deleted"`
+	Reposts         int          `json:"post-reposts"`
+	Poll            *PollFields  `json:"poll,omitempty"`
+	Preview         *LinkPreview `json:"link-preview,omitempty"`
+	Error           string       `json:"error"`
+}
+
+// Body of POST /api/reactions.
+type ReactionFields struct {
+	TargetType string `json:"target-type"`
+	TargetId   string `json:"target-id"`
+	Reaction   string `json:"reaction"`
+}
+
+type PollFields struct {
+	Id         string `json:"poll-id"`
+	TargetType string `json:"target-type"`
+	TargetId   string `json:"target-id"`
+	GroupId    string `json:"group-id"`
+	// Several options can be chosen on multiple choice polls. ClosesAt is 0 for polls that stay open.
+	Multiple      bool               `json:"multiple"`
+	ClosesAt      int                `json:"closes-at"`
+	HideResults   bool               `json:"hide-results"`
+	Closed        bool               `json:"closed"`
+	ResultsHidden bool               `json:"results-hidden"`
+	Options       []PollOptionFields `json:"options"`
+	Voters        int                `json:"voters"`
+	Voted         []string           `json:"voted"`
+}
+
+type PollOptionFields struct {
+	Id    string `json:"option-id"`
+	Text  string `json:"text"`
+	Votes int    `json:"votes"`
+}
+
+// Body of POST /api/polls.
+type BallotFields struct {
+	PollId  string   `json:"poll-id"`
+	Options []string `json:"option-ids"`
 }
 
 type LikesFields struct {
@@ -127,25 +225,43 @@ type LikesFields struct {
 }
 
 type CommentFields struct {
-	CommentId       string `json:"comment-id"`
-	PostId          string `json:"post-id"`
-	Author          string `json:"author"`
-	AuthorImg       string `json:"author-img"`
-	Image           string `json:"comment-image"`
-	Text            string `json:"comment-text"`
-	Thread          string `json:"comment-threads"`
-	Time            int    `json:"comment-time"`
-	CommentLiked    bool   `json:"comment-liked"`
-	Likes           int    `json:"comment-likes"`
-	CommentDisliked bool   `json:"comment-disliked"`
-	Dislikes        int    `json:"comment-dislikes"`
-	CommentAuthor   bool   `json:"comment-author"`
-	Error           string `json:"error"`
+	CommentId       string          `json:"comment-id"`
+	PostId          string          `json:"post-id"`
+	Author          string          `json:"author"`
+	AuthorImg       string          `json:"author-img"`
+	Image           string          `json:"comment-image"`
+	Text            string          `json:"comment-text"`
+	Thread          string          `json:"comment-threads"`
+	Time            int             `json:"comment-time"`
+	CommentLiked    bool            `json:"comment-liked"`
+	Likes           int             `json:"comment-likes"`
+	CommentDisliked bool            `json:"comment-disliked"`
+	Dislikes        int             `json:"comment-dislikes"`
+	Reactions       map[string]int  `json:"reactions"`
+	Reaction        string          `json:"my-reaction"`
+	CommentAuthor   bool            `json:"comment-author"`
+	Mentions        []MentionSpan   `json:"mentions,omitempty"`
+	Edited          bool            `json:"edited"`
+	EditedAt        int             `json:"edited-at"`
+	ParentId        string          `json:"parent-id"`
+	Depth           int             `json:"depth"`
+	ReplyCount      int             `json:"reply-count"`
+	Replies         []CommentFields `json:"replies,omitempty"`
+	RepliesCursor   string          `json:"replies-cursor,omitempty"`
+	CanEdit         bool            `json:"can-edit"`
+	CanDelete       bool            `json:"can-delete"`
+	Error           string          `json:"error"`
+}
+
+type CommentPage struct {
+	Comments   []CommentFields `json:"comments"`
+	NextCursor string          `json:"next-cursor"`
 }
 
 type ReturnComments struct {
-	TotalComments []CommentFields `json:"total-comments"`
-	Post          PostFields      `json:"post-comment"`
+	TotalComments  []CommentFields `json:"total-comments"`
+	CommentsCursor string          `json:"comments-cursor"`
+	Post           PostFields      `json:"post-comment"`
 }
 
 type CommentsAndLikesFields struct {
@@ -167,22 +283,29 @@ type GroupFields struct {
 }
 
 type GroupPostFields struct {
-	Id           string      `json:"group-post-id"`
-	Group        GroupFields `json:"group"`
-	PostId       string      `json:"post-id"`
-	Author       string      `json:"author"`
-	AuthorImg    string      `json:"author-img"`
-	Image        string      `json:"post-image"`
-	Text         string      `json:"post-text-content"`
-	Thread       string      `json:"post-threads"`
-	Likes        int         `json:"post-likes"`
-	PostLiked    bool        `json:"post-liked"`
-	PostComments int         `json:"post-comments"`
-	Dislikes     int         `json:"post-dislikes"`
-	PostDisliked bool        `json:"post-disliked"`
-	PostAuthor   bool        `json:"post-author"`
-	Time         int         `json:"post-time"`
-	Error        string      `json:"error"`
+	Id           string         `json:"group-post-id"`
+	Group        GroupFields    `json:"group"`
+	PostId       string         `json:"post-id"`
+	Author       string         `json:"author"`
+	AuthorImg    string         `json:"author-img"`
+	Image        string         `json:"post-image"`
+	Text         string         `json:"post-text-content"`
+	Thread       string         `json:"post-threads"`
+	Likes        int            `json:"post-likes"`
+	PostLiked    bool           `json:"post-liked"`
+	PostComments int            `json:"post-comments"`
+	Dislikes     int            `json:"post-dislikes"`
+	PostDisliked bool           `json:"post-disliked"`
+	Reactions    map[string]int `json:"reactions"`
+	Reaction     string         `json:"my-reaction"`
+	PostAuthor   bool           `json:"post-author"`
+	Time         int            `json:"post-time"`
+	Mentions     []MentionSpan  `json:"mentions,omitempty"`
+	Edited       bool           `json:"edited"`
+	EditedAt     int            `json:"edited-at"`
+	Poll         *PollFields    `json:"poll,omitempty"`
+	Preview      *LinkPreview   `json:"link-preview,omitempty"`
+	Error        string         `json:"error"`
 }
 
 type GroupsAndLikesFields struct {
@@ -193,8 +316,9 @@ type GroupsAndLikesFields struct {
 }
 
 type ReturnGroupComments struct {
-	TotalComments []CommentFields `json:"total-comments"`
-	Post          GroupPostFields `json:"post-comment"`
+	TotalComments  []CommentFields `json:"total-comments"`
+	CommentsCursor string          `json:"comments-cursor"`
+	Post           GroupPostFields `json:"post-comment"`
 }
 
 type GroupEventFields struct {
@@ -222,3 +346,188 @@ type UpdateStatus struct {
 	User      string `json:"user"`
 	SetStatus string `json:"setStatus"`
 }
+
+type MuteFields struct {
+	User    string `json:"user"`
+	Muted   string `json:"muted"`
+	Type    string `json:"mute-type"`
+	Expires int    `json:"expires"`
+	Error   string `json:"error"`
+}
+
+type UserSummaryFields struct {
+	Nickname   string `json:"nickname"`
+	Firstname  string `json:"first"`
+	Lastname   string `json:"last"`
+	Avatar     string `json:"avatar"`
+	Email      string `json:"email,omitempty"`
+	Status     string `json:"status"`
+	Following  bool   `json:"you-follow"`
+	FollowsYou bool   `json:"follows-you"`
+	Mutual     bool   `json:"mutual"`
+}
+
+type UserSummaryPage struct {
+	Users      []UserSummaryFields `json:"users"`
+	NextCursor string              `json:"next-cursor"`
+}
+
+type SuggestionFields struct {
+	UserSummaryFields
+	Score         int `json:"score"`
+	MutualFollows int `json:"mutual-follows"`
+	SharedGroups  int `json:"shared-groups"`
+	SharedEvents  int `json:"shared-events"`
+	SharedChats   int `json:"shared-chats"`
+}
+
+type MediaFields struct {
+	Id         string            `json:"media-id"`
+	Owner      string            `json:"owner"`
+	Mime       string            `json:"mime"`
+	Size       int               `json:"size"`
+	Width      int               `json:"width"`
+	Height     int               `json:"height"`
+	Url        string            `json:"url"`
+	Thumbnails map[string]string `json:"thumbnails"`
+	Error      string            `json:"error"`
+}
+
+type FollowRequestFields struct {
+	Id        int    `json:"request-id"`
+	Requester string `json:"requester"`
+	Target    string `json:"target"`
+	State     string `json:"state"`
+	Created   int    `json:"created"`
+	Updated   int    `json:"updated"`
+	Error     string `json:"error"`
+}
+
+type AudienceFields struct {
+	Id      string   `json:"audience-id"`
+	Owner   string   `json:"owner"`
+	Name    string   `json:"audience-name"`
+	Members []string `json:"members"`
+	BuiltIn bool     `json:"built-in"`
+	Error   string   `json:"error"`
+}
+
+type FeedItem struct {
+	Kind      string           `json:"kind"`
+	Post      *PostFields      `json:"post,omitempty"`
+	GroupPost *GroupPostFields `json:"group-post,omitempty"`
+}
+
+type FeedPage struct {
+	Items      []FeedItem `json:"items"`
+	NextCursor string     `json:"next-cursor"`
+}
+
+type TagFields struct {
+	Name  string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// A resolved @mention. Start and end are UTF-16 offsets into the text, as used by JavaScript strings.
+type MentionSpan struct {
+	Nickname string `json:"nickname"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+}
+
+type MentionNotification struct {
+	Type     string `json:"mention-type"`
+	Id       string `json:"mention-id"`
+	Context  string `json:"mention-context"`
+	Author   string `json:"mention-author"`
+	Receiver string `json:"mention-receiver"`
+	Time     int    `json:"mention-time"`
+	Seen     bool   `json:"mention-seen"`
+}
+
+// A reply to a comment, sent to the post author and the author of the comment replied to.
+type ReplyNotification struct {
+	Type     string `json:"reply-type"`
+	Id       string `json:"reply-id"`
+	PostId   string `json:"post-id"`
+	Author   string `json:"reply-author"`
+	Receiver string `json:"reply-receiver"`
+	Time     int    `json:"reply-time"`
+	Seen     bool   `json:"reply-seen"`
+}
+
+// A stored version of an edited post, comment, group post or group comment.
+type RevisionFields struct {
+	Type   string `json:"revision-type"`
+	Id     string `json:"revision-id"`
+	Author string `json:"author"`
+	Image  string `json:"image"`
+	Text   string `json:"text"`
+	Thread string `json:"threads"`
+	Time   int    `json:"time"`
+}
+
+type DraftFields struct {
+	Id        string `json:"draft-id"`
+	Author    string `json:"author"`
+	Kind      string `json:"draft-type"`
+	GroupId   string `json:"group-id"`
+	Image     string `json:"post-image"`
+	Text      string `json:"post-text-content"`
+	Thread    string `json:"post-threads"`
+	Privacy   string `json:"privacy"`
+	Viewers   string `json:"viewers"`
+	State     string `json:"state"`
+	PublishAt int    `json:"publish-at"`
+	Created   int    `json:"created"`
+	Updated   int    `json:"updated"`
+	Error     string `json:"error"`
+}
+
+type RepostFields struct {
+	OriginalId string `json:"original-id"`
+	Kind       string `json:"post-kind"`
+	Image      string `json:"post-image"`
+	Text       string `json:"post-text-content"`
+	Thread     string `json:"post-threads"`
+	Privacy    string `json:"privacy"`
+	Viewers    string `json:"viewers"`
+}
+
+type BookmarkFields struct {
+	Type       string `json:"bookmark-type"`
+	Id         string `json:"bookmark-id"`
+	Collection string `json:"collection-id"`
+	Created    int    `json:"created"`
+	Error      string `json:"error"`
+}
+
+type CollectionFields struct {
+	Id      string `json:"collection-id"`
+	Owner   string `json:"owner"`
+	Name    string `json:"collection-name"`
+	Saved   int    `json:"saved"`
+	Created int    `json:"created"`
+	Error   string `json:"error"`
+}
+
+// A highlighted range of a search snippet, in UTF-16 offsets like MentionSpan.
+type TextSpan struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type SearchResultFields struct {
+	Type       string     `json:"result-type"`
+	Id         string     `json:"result-id"`
+	ParentId   string     `json:"parent-id"`
+	Author     string     `json:"author"`
+	Snippet    string     `json:"snippet"`
+	Highlights []TextSpan `json:"highlights"`
+	Time       int        `json:"time"`
+}
+
+type SearchPage struct {
+	Results    []SearchResultFields `json:"results"`
+	NextCursor string               `json:"next-cursor"`
+}
@@ -202,6 +202,8 @@ function App() {
             let removeRequest = {
               "remove-sender": `${obj["notification-followRequest"]["followRequest-username"]}`,
               "remove-receiver": `${obj["notification-followRequest"]["toFollow-username"]}`,
+              // accepted, so closing the notification must not decline the request
+              "remove-typeOfAction": "followRequest-accepted",
             };
             if (ws) {
               ws.send(JSON.stringify(removeRequest));
@@ -11,6 +11,14 @@ import (
 func main() {
 	// Create tabless
 	functions.CreateSqlTables()
+	// Move images stored as data urls into the media store.
+	functions.MigrateDataUrls()
+	// Parse hashtags of content written before tags were stored.
+	functions.MigrateTags()
+	// Move likes from the old like tables into reactions.
+	functions.MigrateLikes()
+	// Track follow requests made before follow_requests existed.
+	functions.MigrateFollowRequests()
 	// Serve files within static and public
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 	http.Handle("/public/", http.StripPrefix("/public/", http.FileServer(http.Dir("public"))))
@@ -30,8 +38,24 @@ func main() {
 	http.HandleFunc("/register", functions.Register)
 	http.HandleFunc("/api/user", functions.GetUserFromSessions)
 	http.HandleFunc("/api/users", functions.UsersApi)
+	http.HandleFunc("/api/users/search", functions.SearchUsersApi)
+	http.HandleFunc("/api/users/followers", functions.FollowListApi)
+	http.HandleFunc("/api/users/following", functions.FollowListApi)
 	http.HandleFunc("/api/followers", functions.FollowersApi)
 	http.HandleFunc("/api/allFollowers", functions.AllFollowersApi)
+	http.HandleFunc("/api/followers/remove", functions.RemoveFollowerApi)
+	http.HandleFunc("/api/mutes", functions.MutesApi)
+	http.HandleFunc("/api/mentions", functions.MentionsApi)
+	http.HandleFunc("/api/replies", functions.RepliesApi)
+	http.HandleFunc("/api/comments", functions.CommentsApi)
+	http.HandleFunc("/api/comments/replies", functions.CommentRepliesApi)
+	http.HandleFunc("/api/presence", functions.PresenceApi)
+	http.HandleFunc("/api/suggestions", functions.SuggestionsApi)
+	http.HandleFunc("/api/follow-requests", functions.FollowRequestsApi)
+	http.HandleFunc("/api/follow-requests/", functions.FollowRequestsApi)
+	http.HandleFunc("/api/audiences", functions.AudiencesApi)
+	http.HandleFunc("/api/media", functions.MediaApi)
+	http.HandleFunc("/media/", functions.ServeMedia)
 	http.HandleFunc("/profile", functions.Profile)
 	http.HandleFunc("/update-user-status", functions.UpdateUserStatus)
 
@@ -43,13 +67,28 @@ func main() {
 	http.HandleFunc("/ws/chat", functions.ServeWs)
 	http.HandleFunc("/ws/user", functions.ServeWs)
 	http.HandleFunc("/ws/group", functions.ServeWs)
+	http.HandleFunc("/api/feed", functions.FeedApi)
+	http.HandleFunc("/api/tags/posts", functions.TagPostsApi)
+	http.HandleFunc("/api/tags/autocomplete", functions.TagAutocompleteApi)
+	http.HandleFunc("/api/tags/trending", functions.TrendingTagsApi)
+	http.HandleFunc("/api/revisions", functions.RevisionsApi)
 	http.HandleFunc("/view-public-posts", functions.ViewPublicPosts)
 	http.HandleFunc("/view-private-posts", functions.ViewPrivatePosts)
 	http.HandleFunc("/create-post", functions.CreatePost)
+	http.HandleFunc("/api/drafts", functions.DraftsApi)
 	http.HandleFunc("/edit-post", functions.EditPost)
+	http.HandleFunc("/api/reactions", functions.ReactionsApi)
+	http.HandleFunc("/api/polls", functions.PollsApi)
+	http.HandleFunc("/api/stories", functions.StoriesApi)
+	http.HandleFunc("/api/stories/views", functions.StoryViewsApi)
+	http.HandleFunc("/api/search", functions.SearchApi)
+	http.HandleFunc("/api/reposts", functions.RepostsApi)
+	http.HandleFunc("/api/bookmarks", functions.BookmarksApi)
+	http.HandleFunc("/api/collections", functions.CollectionsApi)
 	http.HandleFunc("/post-interactions", functions.PostInteractions)
 	http.HandleFunc("/create-comment", functions.CreateComment)
 	http.HandleFunc("/comment-interactions", functions.CommentInteractions)
+	http.HandleFunc("/edit-comment", functions.EditComment)
 	http.HandleFunc("/create-group-post", functions.CreateGroupPost)
 	http.HandleFunc("/edit-group-post", functions.EditGroupPost)
 	http.HandleFunc("/group-post-interactions", functions.GroupPostInteractions)
@@ -62,6 +101,7 @@ func main() {
 	http.HandleFunc("/send-group-request", functions.SendGroupRequest)
 	http.HandleFunc("/create-group-post-comment", functions.CreateGroupPostComment)
 	http.HandleFunc("/group-post-comment-interaction", functions.GroupPostCommentInteractions)
+	http.HandleFunc("/edit-group-post-comment", functions.EditGroupPostComment)
 	http.HandleFunc("/create-group-event", functions.CreateGroupEvent)
 	http.HandleFunc("/get-group-events", functions.GetGroupEvents)
 	http.HandleFunc("/get-requests", functions.GetRequests)
@@ -70,6 +110,8 @@ func main() {
 
 	go functions.H.Run()
 	go functions.SqlExec.ExecuteStatements()
+	go functions.RunSuggestionJob()
+	go functions.RunScheduler()
 
 	fmt.Printf("SOCIAL-NETWORK serving at http://localhost:8080\n")
 	if err := http.ListenAndServe(":8080", nil); err != nil {